@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
+)
+
+// dockerExecCreateRequest mirrors the subset of Docker's
+// ContainerExecCreate request body that Shadow sessions need.
+type dockerExecCreateRequest struct {
+	Cmd          []string `json:"Cmd"`
+	Env          []string `json:"Env"`
+	Tty          bool     `json:"Tty"`
+	AttachStdin  bool     `json:"AttachStdin"`
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+}
+
+// registerDockerRoutes wires up a Docker Engine-compatible facade under
+// /api/v1/docker/, so unmodified Docker clients (docker CLI via DOCKER_HOST,
+// docker-py, testcontainers) can attach to a session's container without
+// being handed raw access to the host Docker socket. Every {name} path
+// segment is a session ID, not a Docker container ID; resolveContainer
+// translates between the two and enforces that the caller's token owns
+// the session before any request reaches dr.client.
+func (gw *APIGateway) registerDockerRoutes(r *mux.Router) {
+	docker := r.PathPrefix("/api/v1/docker").Subrouter()
+
+	docker.HandleFunc("/containers/json", gw.dockerListContainers).Methods("GET")
+	docker.HandleFunc("/containers/{name}/json", gw.dockerInspectContainer).Methods("GET")
+	docker.HandleFunc("/containers/{name}/logs", gw.dockerContainerLogs).Methods("GET")
+	docker.HandleFunc("/containers/{name}/stats", gw.dockerContainerStats).Methods("GET")
+	docker.HandleFunc("/containers/{name}/kill", gw.dockerKillContainer).Methods("POST")
+	docker.HandleFunc("/containers/{name}", gw.dockerRemoveContainer).Methods("DELETE")
+	docker.HandleFunc("/containers/{name}/exec", gw.dockerExecCreate).Methods("POST")
+	docker.HandleFunc("/exec/{id}/start", gw.dockerExecStart).Methods("POST")
+}
+
+// resolveContainer translates a {name} path param (a session ID) into the
+// underlying Docker container ID, after checking that the token presented
+// in X-Shadow-Token owns that session.
+func (gw *APIGateway) resolveContainer(r *http.Request) (containerID string, sessionID string, err error) {
+	vars := mux.Vars(r)
+	sessionID = vars["name"]
+
+	if _, err := gw.authenticateSession(r, sessionID); err != nil {
+		return "", "", errDockerAccessDenied
+	}
+
+	rc, err := gw.dockerRunner.LookupBySession(sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return rc.ID, sessionID, nil
+}
+
+// resolveExecSession authenticates the caller against the session that
+// owns execID's container — the same ownership check resolveContainer
+// gives every other Docker-facade route. execID's path doesn't carry a
+// session ID directly, so this asks Docker which container the exec
+// belongs to first, then matches that container against the caller's
+// running sessions before any of it reaches dr.client.
+func (gw *APIGateway) resolveExecSession(r *http.Request, execID string) error {
+	inspect, err := gw.dockerRunner.Client().ContainerExecInspect(r.Context(), execID)
+	if err != nil {
+		return err
+	}
+
+	for sessionID, rc := range gw.dockerRunner.GetRunningContainers() {
+		if rc.ID != inspect.ContainerID {
+			continue
+		}
+		if _, err := gw.authenticateSession(r, sessionID); err != nil {
+			return errDockerAccessDenied
+		}
+		return nil
+	}
+
+	return errDockerAccessDenied
+}
+
+var errDockerAccessDenied = &dockerFacadeError{"token does not own this session"}
+
+type dockerFacadeError struct{ msg string }
+
+func (e *dockerFacadeError) Error() string { return e.msg }
+
+func (gw *APIGateway) dockerListContainers(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(gw.config.Auth.TokenHeader)
+	session, err := gw.sessionStore.Authenticate(token)
+	if err != nil {
+		gw.writeDockerJSON(w, http.StatusOK, []types.Container{})
+		return
+	}
+
+	var items []types.Container
+	for sessionID, rc := range gw.dockerRunner.GetRunningContainers() {
+		if sessionID != session.ID {
+			continue
+		}
+		items = append(items, types.Container{
+			ID:      rc.ID,
+			Names:   []string{"/" + sessionID},
+			State:   rc.Status,
+			Created: rc.StartTime.Unix(),
+		})
+	}
+
+	gw.writeDockerJSON(w, http.StatusOK, items)
+}
+
+func (gw *APIGateway) dockerInspectContainer(w http.ResponseWriter, r *http.Request) {
+	containerID, _, err := gw.resolveContainer(r)
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	info, err := gw.dockerRunner.Client().ContainerInspect(r.Context(), containerID)
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	gw.writeDockerJSON(w, http.StatusOK, info)
+}
+
+func (gw *APIGateway) dockerContainerLogs(w http.ResponseWriter, r *http.Request) {
+	containerID, _, err := gw.resolveContainer(r)
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	reader, err := gw.dockerRunner.Client().ContainerLogs(r.Context(), containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     q.Get("follow") == "1" || q.Get("follow") == "true",
+		Timestamps: q.Get("timestamps") == "1" || q.Get("timestamps") == "true",
+		Tail:       q.Get("tail"),
+	})
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, reader)
+}
+
+func (gw *APIGateway) dockerContainerStats(w http.ResponseWriter, r *http.Request) {
+	containerID, _, err := gw.resolveContainer(r)
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	stream := r.URL.Query().Get("stream") != "0" && r.URL.Query().Get("stream") != "false"
+
+	stats, err := gw.dockerRunner.Client().ContainerStats(r.Context(), containerID, stream)
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+	defer stats.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, stats.Body)
+}
+
+func (gw *APIGateway) dockerKillContainer(w http.ResponseWriter, r *http.Request) {
+	containerID, _, err := gw.resolveContainer(r)
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	signal := r.URL.Query().Get("signal")
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+
+	if err := gw.dockerRunner.Client().ContainerKill(r.Context(), containerID, signal); err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (gw *APIGateway) dockerRemoveContainer(w http.ResponseWriter, r *http.Request) {
+	containerID, _, err := gw.resolveContainer(r)
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "1" || r.URL.Query().Get("force") == "true"
+
+	err = gw.dockerRunner.Client().ContainerRemove(r.Context(), containerID, types.ContainerRemoveOptions{
+		Force: force,
+	})
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (gw *APIGateway) dockerExecCreate(w http.ResponseWriter, r *http.Request) {
+	containerID, _, err := gw.resolveContainer(r)
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	var req dockerExecCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gw.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := gw.dockerRunner.Client().ContainerExecCreate(r.Context(), containerID, types.ExecConfig{
+		Cmd:          req.Cmd,
+		Env:          req.Env,
+		Tty:          req.Tty,
+		AttachStdin:  req.AttachStdin,
+		AttachStdout: req.AttachStdout,
+		AttachStderr: req.AttachStderr,
+	})
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	gw.writeDockerJSON(w, http.StatusCreated, map[string]string{"Id": resp.ID})
+}
+
+func (gw *APIGateway) dockerExecStart(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	execID := vars["id"]
+
+	if err := gw.resolveExecSession(r, execID); err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+
+	var req struct {
+		Detach bool `json:"Detach"`
+		Tty    bool `json:"Tty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gw.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Detach {
+		err := gw.dockerRunner.Client().ContainerExecStart(r.Context(), execID, types.ExecStartCheck{Tty: req.Tty})
+		if err != nil {
+			gw.writeDockerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	hijack, err := gw.dockerRunner.Client().ContainerExecAttach(r.Context(), execID, types.ExecStartCheck{Tty: req.Tty})
+	if err != nil {
+		gw.writeDockerError(w, err)
+		return
+	}
+	defer hijack.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, hijack.Reader)
+}
+
+func (gw *APIGateway) writeDockerJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (gw *APIGateway) writeDockerError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if err == errDockerAccessDenied {
+		status = http.StatusForbidden
+	}
+	if client.IsErrNotFound(err) {
+		status = http.StatusNotFound
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+}
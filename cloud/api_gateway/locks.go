@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultLockTTL = 30 * time.Second
+	maxLockTTL     = 10 * time.Minute
+)
+
+type lockAcquireRequest struct {
+	Resource string `json:"resource"`
+	Owner    string `json:"owner"`
+	Source   string `json:"source"`
+	Read     bool   `json:"read"`
+	TTLSec   int    `json:"ttl_seconds,omitempty"`
+}
+
+type lockTokenRequest struct {
+	Owner string `json:"owner"`
+	UID   string `json:"uid"`
+}
+
+func (gw *APIGateway) handleAcquireLock(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	if _, err := gw.authenticateSession(r, sessionID); err != nil {
+		gw.sendError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var req lockAcquireRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gw.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultLockTTL
+	if req.TTLSec > 0 {
+		ttl = time.Duration(req.TTLSec) * time.Second
+		if ttl > maxLockTTL {
+			ttl = maxLockTTL
+		}
+	}
+
+	var (
+		token LockToken
+		err   error
+	)
+	if req.Read {
+		token, err = gw.sessionStore.AcquireReadLock(sessionID, req.Resource, req.Owner, req.Source, ttl)
+	} else {
+		token, err = gw.sessionStore.AcquireLock(sessionID, req.Resource, req.Owner, req.Source, ttl)
+	}
+	if err != nil {
+		gw.sendError(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	gw.sendSuccess(w, map[string]interface{}{
+		"token": token,
+		"uid":   string(token),
+	})
+}
+
+func (gw *APIGateway) handleRefreshLock(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+	token := LockToken(mux.Vars(r)["token"])
+
+	if _, err := gw.authenticateSession(r, sessionID); err != nil {
+		gw.sendError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var req lockTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gw.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := gw.sessionStore.RefreshLock(token, req.Owner, req.UID); err != nil {
+		gw.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	gw.sendSuccess(w, map[string]string{"status": "refreshed"})
+}
+
+func (gw *APIGateway) handleReleaseLock(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+	token := LockToken(mux.Vars(r)["token"])
+
+	if _, err := gw.authenticateSession(r, sessionID); err != nil {
+		gw.sendError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var req lockTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gw.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := gw.sessionStore.ReleaseLock(token, req.Owner, req.UID); err != nil {
+		gw.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	gw.sendSuccess(w, map[string]string{"status": "released"})
+}
+
+// handleTopLocks backs the admin/debug view of currently-held session
+// locks. ?count=N caps the result (0 or unset means no cap); ?stale=true
+// also includes locks the refresher is about to reap.
+func (gw *APIGateway) handleTopLocks(w http.ResponseWriter, r *http.Request) {
+	count := 0
+	if v := r.URL.Query().Get("count"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			count = n
+		}
+	}
+	includeStale := r.URL.Query().Get("stale") == "true"
+
+	locks := gw.sessionStore.TopLocks(count, includeStale)
+	gw.sendSuccess(w, map[string]interface{}{
+		"locks": locks,
+	})
+}
@@ -29,9 +29,15 @@ type Config struct {
 		TokenLength int    `json:"token_length"`
 	} `json:"auth"`
 	Session struct {
-		TTLHours     int    `json:"ttl_hours"`
-		MaxPayloadMB int    `json:"max_payload_mb"`
-		Storage      string `json:"storage"`
+		TTLHours      int    `json:"ttl_hours"`
+		GCIntervalMin int    `json:"gc_interval_minutes"`
+		MaxPayloadMB  int    `json:"max_payload_mb"`
+		// Storage selects the SessionRepository backend: "memory"
+		// (default), "bolt", or "redis".
+		Storage         string `json:"storage"`
+		BoltPath        string `json:"bolt_path"`
+		RedisAddr       string `json:"redis_addr"`
+		EncryptionKeyHex string `json:"encryption_key_hex"`
 	} `json:"session"`
 }
 
@@ -39,21 +45,36 @@ type APIGateway struct {
 	config        *Config
 	sessionStore  *SessionStore
 	dockerRunner  *DockerRunner
+	syncWorker    *SyncWorker
 	upgrader      websocket.Upgrader
 }
 
 type SessionInitRequest struct {
 	WorkspacePath string            `json:"workspace_path"`
 	Metadata      map[string]string `json:"metadata"`
+	Limits        SessionLimits     `json:"limits"`
+	// Dockerfile and BuildContext are optional; when Dockerfile is set,
+	// DockerRunner builds a session-specific image instead of using the
+	// default base image.
+	Dockerfile   string            `json:"dockerfile,omitempty"`
+	BuildContext map[string]string `json:"build_context,omitempty"`
+	RunAsRoot    bool              `json:"run_as_root,omitempty"`
 }
 
 type SessionSyncRequest struct {
-	SessionID string                 `json:"session_id"`
-	Delta     map[string]interface{} `json:"delta"`
-	Snapshot  map[string]interface{} `json:"snapshot"`
+	// SessionID is the bearer token returned from /session/init, not the
+	// raw session ID.
+	SessionID string `json:"session_id"`
+	ReplicaID string `json:"replica_id"`
+	// Ops carries an incremental CRDT op-set; State carries a full
+	// replace. Exactly one should be set.
+	Ops   *CRDTOpSet             `json:"ops,omitempty"`
+	State map[string]interface{} `json:"state,omitempty"`
 }
 
 type SessionExecuteRequest struct {
+	// SessionID is the bearer token returned from /session/init, not the
+	// raw session ID.
 	SessionID   string   `json:"session_id"`
 	Command     string   `json:"command"`
 	Environment []string `json:"environment"`
@@ -71,13 +92,32 @@ func NewAPIGateway() (*APIGateway, error) {
 		return nil, err
 	}
 
-	sessionStore := NewSessionStore()
+	repo, err := newSessionRepositoryFromConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize session repository: %v", err)
+	}
+
+	sessionStore := NewSessionStore(
+		repo,
+		time.Duration(config.Session.TTLHours)*time.Hour,
+		time.Duration(config.Session.GCIntervalMin)*time.Minute,
+	)
 	dockerRunner := NewDockerRunner()
+	sessionStore.OnExpire = dockerRunner.RemoveSessionImage
+
+	syncWorker, err := NewSyncWorker(sessionStore, 4, filepath.Join("cloud", "data", "wal"))
+	if err != nil {
+		return nil, err
+	}
+	if err := syncWorker.Start(); err != nil {
+		return nil, err
+	}
 
 	return &APIGateway{
 		config:       config,
 		sessionStore: sessionStore,
 		dockerRunner: dockerRunner,
+		syncWorker:   syncWorker,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for development
@@ -98,10 +138,26 @@ func (gw *APIGateway) Start() error {
 	api.HandleFunc("/session/execute", gw.handleSessionExecute).Methods("POST")
 	api.HandleFunc("/session/list", gw.handleSessionList).Methods("GET")
 	api.HandleFunc("/session/{id}", gw.handleSessionGet).Methods("GET")
+	api.HandleFunc("/session/{id}/stats", gw.handleSessionStatsSnapshot).Methods("GET")
+	api.HandleFunc("/sync/wal/stats", gw.handleSyncWALStats).Methods("GET")
+	api.HandleFunc("/session/{id}/lock", gw.handleAcquireLock).Methods("POST")
+	api.HandleFunc("/session/{id}/lock/{token}/refresh", gw.handleRefreshLock).Methods("POST")
+	api.HandleFunc("/session/{id}/lock/{token}", gw.handleReleaseLock).Methods("DELETE")
+	api.HandleFunc("/admin/locks", gw.handleTopLocks).Methods("GET")
+	api.HandleFunc("/session/rotate-secret", gw.handleRotateSecret).Methods("POST")
+
+	// Docker Engine-compatible facade, scoped per session token
+	gw.registerDockerRoutes(r)
 
 	// WebSocket for log streaming
 	r.HandleFunc("/ws/logs/{session_id}", gw.handleLogStream)
 
+	// WebSocket for interactive, PTY-backed exec (bidirectional stdin/stdout)
+	r.HandleFunc("/ws/session/{id}/exec", gw.handleSessionExec)
+
+	// WebSocket for live per-session resource stats
+	r.HandleFunc("/ws/stats/{session_id}", gw.handleStatsStream)
+
 	// CORS middleware
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -149,7 +205,7 @@ func (gw *APIGateway) handleSessionInit(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	session, err := gw.sessionStore.CreateSession(req.WorkspacePath, req.Metadata)
+	session, token, err := gw.sessionStore.CreateSession(req.WorkspacePath, req.Metadata, req.Limits, req.Dockerfile, req.BuildContext, req.RunAsRoot)
 	if err != nil {
 		gw.sendError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -157,6 +213,9 @@ func (gw *APIGateway) handleSessionInit(w http.ResponseWriter, r *http.Request)
 
 	gw.sendSuccess(w, map[string]interface{}{
 		"session_id": session.ID,
+		// token authenticates every subsequent session/sync/execute call;
+		// it is never recoverable again once this response is sent.
+		"token":      token,
 		"created_at": session.CreatedAt,
 		"expires_at": session.ExpiresAt,
 	})
@@ -169,7 +228,23 @@ func (gw *APIGateway) handleSessionSync(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := gw.sessionStore.SyncSession(req.SessionID, req.Delta, req.Snapshot)
+	// Authenticate once up front so both the incremental and full-replace
+	// branches are token-gated, then operate on the resolved session ID.
+	session, err := gw.sessionStore.Authenticate(req.SessionID)
+	if err != nil {
+		gw.sendError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case req.State != nil:
+		err = gw.sessionStore.ReplaceSessionState(session.ID, req.ReplicaID, req.State)
+	case req.Ops != nil:
+		err = gw.sessionStore.syncSessionByID(session.ID, req.ReplicaID, *req.Ops)
+	default:
+		gw.sendError(w, "request must include ops or state", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		gw.sendError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -210,22 +285,72 @@ func (gw *APIGateway) handleSessionExecute(w http.ResponseWriter, r *http.Reques
 
 func (gw *APIGateway) handleSessionList(w http.ResponseWriter, r *http.Request) {
 	sessions := gw.sessionStore.ListSessions()
+
+	// Strip the Argon2id salt/hash the same way handleSessionGet does —
+	// these are internal-only fields, never meant to cross the API.
+	public := make([]*Session, len(sessions))
+	for i, session := range sessions {
+		redacted := *session
+		redacted.AuthSalt = nil
+		redacted.AuthHash = nil
+		public[i] = &redacted
+	}
+
 	gw.sendSuccess(w, map[string]interface{}{
-		"sessions": sessions,
+		"sessions": public,
 	})
 }
 
 func (gw *APIGateway) handleSessionGet(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	sessionID := vars["id"]
+	sessionID := mux.Vars(r)["id"]
 
-	session, err := gw.sessionStore.GetSession(sessionID)
-	if err != nil {
+	session, err := gw.sessionStore.GetSession(r.URL.Query().Get("token"))
+	if err != nil || session.ID != sessionID {
 		gw.sendError(w, "Session not found", http.StatusNotFound)
 		return
 	}
 
-	gw.sendSuccess(w, session)
+	public := *session
+	public.AuthSalt = nil
+	public.AuthHash = nil
+	gw.sendSuccess(w, &public)
+}
+
+// handleRotateSecret requires the session's current token to authorize
+// rotation, then issues a new one; every token minted before the
+// rotation stops working immediately.
+func (gw *APIGateway) handleRotateSecret(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gw.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	session, err := gw.sessionStore.Authenticate(req.SessionID)
+	if err != nil {
+		gw.sendError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	token, err := gw.sessionStore.RotateSecret(session.ID)
+	if err != nil {
+		gw.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	gw.sendSuccess(w, map[string]string{"token": token})
+}
+
+func (gw *APIGateway) handleSyncWALStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := gw.syncWorker.WALStats()
+	if err != nil {
+		gw.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	gw.sendSuccess(w, stats)
 }
 
 func (gw *APIGateway) handleLogStream(w http.ResponseWriter, r *http.Request) {
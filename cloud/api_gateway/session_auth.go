@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// authenticateSession authenticates the token carried in the
+// X-Shadow-Token header against sessionStore and verifies the resolved
+// session matches pathSessionID, the same check handleSessionGet does.
+// Anything that resolves work from a path/route session ID (the Docker
+// facade, interactive exec, stats streaming) must go through this
+// instead of trusting the path value directly — a path session ID is
+// just a lookup key, not proof of ownership.
+func (gw *APIGateway) authenticateSession(r *http.Request, pathSessionID string) (*Session, error) {
+	token := r.Header.Get(gw.config.Auth.TokenHeader)
+
+	session, err := gw.sessionStore.Authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+	if session.ID != pathSessionID {
+		return nil, fmt.Errorf("session not found: %s", pathSessionID)
+	}
+
+	return session, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+)
+
+// newSessionRepositoryFromConfig selects and constructs the SessionRepository
+// backend named by cfg.Session.Storage. Persistent backends (bolt, redis)
+// require an encryption key so sessions are never written to disk or
+// Redis in plaintext.
+func newSessionRepositoryFromConfig(cfg *Config) (SessionRepository, error) {
+	switch cfg.Session.Storage {
+	case "", "memory":
+		return NewMemoryRepository(), nil
+
+	case "bolt":
+		encryptor, err := newSessionEncryptorFromConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		path := cfg.Session.BoltPath
+		if path == "" {
+			path = filepath.Join("cloud", "data", "sessions.bolt")
+		}
+		return NewBoltRepository(path, encryptor)
+
+	case "redis":
+		encryptor, err := newSessionEncryptorFromConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		addr := cfg.Session.RedisAddr
+		if addr == "" {
+			addr = "localhost:6379"
+		}
+		return NewRedisRepository(addr, encryptor), nil
+
+	default:
+		return nil, fmt.Errorf("unknown session storage backend: %s", cfg.Session.Storage)
+	}
+}
+
+func newSessionEncryptorFromConfig(cfg *Config) (*SessionEncryptor, error) {
+	if cfg.Session.EncryptionKeyHex == "" {
+		return nil, fmt.Errorf("session.encryption_key_hex is required for storage=%s", cfg.Session.Storage)
+	}
+
+	key, err := hex.DecodeString(cfg.Session.EncryptionKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session.encryption_key_hex: %v", err)
+	}
+
+	return NewSessionEncryptor(key)
+}
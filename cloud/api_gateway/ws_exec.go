@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// execHandshake is the initial JSON frame a client sends right after the
+// WebSocket upgrade, before any exec is created.
+type execHandshake struct {
+	Command []string `json:"command"`
+	Env     []string `json:"env"`
+	User    string   `json:"user"`
+}
+
+// execControlMessage is a JSON text frame used for everything that isn't
+// raw stdin: terminal resizes and signals.
+type execControlMessage struct {
+	Resize *struct {
+		Cols uint `json:"cols"`
+		Rows uint `json:"rows"`
+	} `json:"resize,omitempty"`
+	Signal string `json:"signal,omitempty"`
+}
+
+const (
+	execStreamStdout byte = 0
+	execStreamStderr byte = 1
+)
+
+// handleSessionExec upgrades to a WebSocket and drives an interactive,
+// TTY-backed exec in the session's container, unlike handleSessionExecute
+// which only fires a one-shot command. Stdin travels in over binary WS
+// frames, output comes back in binary frames tagged with a 1-byte stream
+// marker, and text frames carry control messages (resize, signal). The
+// exec runs with a TTY, so Docker merges stderr into stdout: every output
+// frame carries execStreamStdout and execStreamStderr is never sent.
+func (gw *APIGateway) handleSessionExec(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	if _, err := gw.authenticateSession(r, sessionID); err != nil {
+		gw.sendError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	rc, err := gw.dockerRunner.LookupBySession(sessionID)
+	if err != nil {
+		gw.sendError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	conn, err := gw.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	var handshake execHandshake
+	if err := conn.ReadJSON(&handshake); err != nil {
+		log.Printf("exec handshake error for session %s: %v", sessionID, err)
+		return
+	}
+
+	ctx := r.Context()
+	execResp, err := gw.dockerRunner.Client().ContainerExecCreate(ctx, rc.ID, types.ExecConfig{
+		Cmd:          handshake.Command,
+		Env:          handshake.Env,
+		User:         handshake.User,
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		log.Printf("exec create error for session %s: %v", sessionID, err)
+		return
+	}
+
+	hijack, err := gw.dockerRunner.Client().ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: true})
+	if err != nil {
+		log.Printf("exec attach error for session %s: %v", sessionID, err)
+		return
+	}
+
+	done := make(chan struct{})
+
+	// stdout/stderr -> WS
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := hijack.Reader.Read(buf)
+			if n > 0 {
+				frame := append([]byte{execStreamStdout}, buf[:n]...)
+				if werr := conn.WriteMessage(websocket.BinaryMessage, frame); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("exec read error for session %s: %v", sessionID, err)
+				}
+				return
+			}
+		}
+	}()
+
+	// WS -> stdin, plus control frames (resize, signal)
+readLoop:
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		switch msgType {
+		case websocket.BinaryMessage:
+			if _, err := hijack.Conn.Write(data); err != nil {
+				log.Printf("exec stdin write error for session %s: %v", sessionID, err)
+				break readLoop
+			}
+		case websocket.TextMessage:
+			var ctrl execControlMessage
+			if err := json.Unmarshal(data, &ctrl); err != nil {
+				continue
+			}
+			if ctrl.Resize != nil {
+				gw.dockerRunner.Client().ContainerExecResize(ctx, execResp.ID, types.ResizeOptions{
+					Height: ctrl.Resize.Rows,
+					Width:  ctrl.Resize.Cols,
+				})
+			}
+			if ctrl.Signal != "" {
+				gw.dockerRunner.Client().ContainerKill(ctx, rc.ID, ctrl.Signal)
+			}
+		}
+	}
+
+	// Unblocks the stdout/stderr goroutine's hijack.Reader.Read, which
+	// otherwise has nothing to make it return once the client side goes
+	// away before the exec'd command itself exits.
+	hijack.Close()
+	<-done
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultStatsInterval = 1 * time.Second
+
+// dockerStatsSample is the subset of Docker's stats JSON this package reads
+// to derive percentages; the rest of the payload is ignored.
+type dockerStatsSample struct {
+	Read    time.Time `json:"read"`
+	PidsStats struct {
+		Current uint64 `json:"current"`
+	} `json:"pids_stats"`
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+		SystemUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs  uint64 `json:"online_cpus"`
+	} `json:"cpu_stats"`
+	PreCPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+		SystemUsage uint64 `json:"system_cpu_usage"`
+	} `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64 `json:"usage"`
+		Limit uint64 `json:"limit"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+	BlkioStats struct {
+		IoServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+// SessionStats is what Shadow clients actually want out of a raw Docker
+// stats sample: percentages and deltas, not cumulative counters. Net and
+// IO are deltas since the previous sample of the same stream; the first
+// frame of a stream and every REST snapshot have no previous sample, so
+// there they hold the cumulative totals.
+type SessionStats struct {
+	SessionID  string    `json:"session_id"`
+	Timestamp  time.Time `json:"ts"`
+	CPUPercent float64   `json:"cpu_percent"`
+	MemBytes   uint64    `json:"mem_bytes"`
+	MemLimit   uint64    `json:"mem_limit"`
+	MemPercent float64   `json:"mem_percent"`
+	Net        struct {
+		RxBytes uint64 `json:"rx"`
+		TxBytes uint64 `json:"tx"`
+	} `json:"net"`
+	IO struct {
+		ReadBytes  uint64 `json:"read"`
+		WriteBytes uint64 `json:"write"`
+	} `json:"io"`
+	Pids uint64 `json:"pids"`
+}
+
+func deriveSessionStats(sessionID string, sample dockerStatsSample, prev *dockerStatsSample) SessionStats {
+	stats := SessionStats{
+		SessionID: sessionID,
+		Timestamp: sample.Read,
+		MemBytes:  sample.MemoryStats.Usage,
+		MemLimit:  sample.MemoryStats.Limit,
+		Pids:      sample.PidsStats.Current,
+	}
+
+	if sample.MemoryStats.Limit > 0 {
+		stats.MemPercent = float64(sample.MemoryStats.Usage) / float64(sample.MemoryStats.Limit) * 100
+	}
+
+	cpuDelta := float64(sample.CPUStats.CPUUsage.TotalUsage) - float64(sample.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(sample.CPUStats.SystemUsage) - float64(sample.PreCPUStats.SystemUsage)
+	if systemDelta > 0 && cpuDelta > 0 {
+		onlineCPUs := sample.CPUStats.OnlineCPUs
+		if onlineCPUs == 0 {
+			onlineCPUs = 1
+		}
+		stats.CPUPercent = (cpuDelta / systemDelta) * float64(onlineCPUs) * 100
+	}
+
+	var rx, tx uint64
+	for _, iface := range sample.Networks {
+		rx += iface.RxBytes
+		tx += iface.TxBytes
+	}
+	stats.Net.RxBytes = rx
+	stats.Net.TxBytes = tx
+	if prev != nil {
+		var prevRx, prevTx uint64
+		for _, iface := range prev.Networks {
+			prevRx += iface.RxBytes
+			prevTx += iface.TxBytes
+		}
+		if rx >= prevRx {
+			stats.Net.RxBytes = rx - prevRx
+		}
+		if tx >= prevTx {
+			stats.Net.TxBytes = tx - prevTx
+		}
+	}
+
+	var read, write uint64
+	for _, entry := range sample.BlkioStats.IoServiceBytesRecursive {
+		switch entry.Op {
+		case "Read":
+			read += entry.Value
+		case "Write":
+			write += entry.Value
+		}
+	}
+	stats.IO.ReadBytes = read
+	stats.IO.WriteBytes = write
+	if prev != nil {
+		var prevRead, prevWrite uint64
+		for _, entry := range prev.BlkioStats.IoServiceBytesRecursive {
+			switch entry.Op {
+			case "Read":
+				prevRead += entry.Value
+			case "Write":
+				prevWrite += entry.Value
+			}
+		}
+		if read >= prevRead {
+			stats.IO.ReadBytes = read - prevRead
+		}
+		if write >= prevWrite {
+			stats.IO.WriteBytes = write - prevWrite
+		}
+	}
+
+	return stats
+}
+
+// handleStatsStream streams derived resource metrics for a session's
+// container at defaultStatsInterval, sampling the raw Docker stats API and
+// computing the percentages/deltas that Docker itself leaves to clients.
+func (gw *APIGateway) handleStatsStream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["session_id"]
+
+	if _, err := gw.authenticateSession(r, sessionID); err != nil {
+		log.Printf("stats stream: session not found: %s", sessionID)
+		return
+	}
+
+	rc, err := gw.dockerRunner.LookupBySession(sessionID)
+	if err != nil {
+		log.Printf("stats stream: session not found: %s", sessionID)
+		return
+	}
+
+	conn, err := gw.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(defaultStatsInterval)
+	defer ticker.Stop()
+
+	var prev *dockerStatsSample
+	for range ticker.C {
+		sample, err := gw.fetchStatsSample(r.Context(), rc.ID)
+		if err != nil {
+			log.Printf("stats sample error for session %s: %v", sessionID, err)
+			return
+		}
+
+		stats := deriveSessionStats(sessionID, sample, prev)
+		prevSample := sample
+		prev = &prevSample
+
+		if err := conn.WriteJSON(stats); err != nil {
+			return
+		}
+	}
+}
+
+// handleSessionStatsSnapshot returns a single REST sample, for clients that
+// don't want to hold open a WebSocket just to poll occasionally.
+func (gw *APIGateway) handleSessionStatsSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	if _, err := gw.authenticateSession(r, sessionID); err != nil {
+		gw.sendError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	rc, err := gw.dockerRunner.LookupBySession(sessionID)
+	if err != nil {
+		gw.sendError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	sample, err := gw.fetchStatsSample(r.Context(), rc.ID)
+	if err != nil {
+		gw.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	gw.sendSuccess(w, deriveSessionStats(sessionID, sample, nil))
+}
+
+func (gw *APIGateway) fetchStatsSample(ctx context.Context, containerID string) (dockerStatsSample, error) {
+	resp, err := gw.dockerRunner.Client().ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return dockerStatsSample{}, err
+	}
+	defer resp.Body.Close()
+
+	var sample dockerStatsSample
+	if err := json.NewDecoder(resp.Body).Decode(&sample); err != nil {
+		return dockerStatsSample{}, err
+	}
+
+	return sample, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	bkclient "github.com/moby/buildkit/client"
+)
+
+// buildkitAddr is where a host buildkitd (or an embedded one) is expected
+// to be listening. In production this should come from config instead of
+// being hardcoded.
+const buildkitAddr = "unix:///run/buildkit/buildkitd.sock"
+
+// buildSessionImage builds a session-specific image from the session's
+// Dockerfile/BuildContext via BuildKit and returns the image tag to run.
+// Sessions without a Dockerfile keep using the shared base image.
+func (dr *DockerRunner) buildSessionImage(session *Session, tempDir string) (string, error) {
+	if session.Dockerfile == "" {
+		return dr.baseImage, nil
+	}
+
+	contextDir := filepath.Join(tempDir, ".shadow-build", "context")
+	dockerfileDir := filepath.Join(tempDir, ".shadow-build", "dockerfile")
+	if err := os.MkdirAll(contextDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to prepare build context: %v", err)
+	}
+	if err := os.MkdirAll(dockerfileDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to prepare dockerfile dir: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dockerfileDir, "Dockerfile"), []byte(session.Dockerfile), 0644); err != nil {
+		return "", fmt.Errorf("failed to write Dockerfile: %v", err)
+	}
+
+	for name, content := range session.BuildContext {
+		dest := filepath.Join(contextDir, name)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", fmt.Errorf("failed to prepare build context file %s: %v", name, err)
+		}
+		if err := os.WriteFile(dest, []byte(content), 0644); err != nil {
+			return "", fmt.Errorf("failed to write build context file %s: %v", name, err)
+		}
+	}
+
+	ctx := context.Background()
+	bk, err := bkclient.New(ctx, buildkitAddr)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to buildkitd: %v", err)
+	}
+	defer bk.Close()
+
+	tag := fmt.Sprintf("shadow-session:%s", session.ID)
+	cacheKey := workspaceCacheKey(session.WorkspacePath)
+
+	solveOpt := bkclient.SolveOpt{
+		Frontend: "dockerfile.v0",
+		FrontendAttrs: map[string]string{
+			"filename": "Dockerfile",
+		},
+		LocalDirs: map[string]string{
+			"context":    contextDir,
+			"dockerfile": dockerfileDir,
+		},
+		Exports: []bkclient.ExportEntry{
+			{
+				Type:  bkclient.ExporterImage,
+				Attrs: map[string]string{"name": tag, "push": "false"},
+			},
+		},
+		CacheExports: []bkclient.CacheOptionsEntry{
+			{Type: "local", Attrs: map[string]string{"dest": dr.cacheDir(cacheKey), "mode": "max"}},
+		},
+		CacheImports: []bkclient.CacheOptionsEntry{
+			{Type: "local", Attrs: map[string]string{"src": dr.cacheDir(cacheKey)}},
+		},
+	}
+
+	statusCh := make(chan *bkclient.SolveStatus)
+	done := make(chan error, 1)
+
+	go func() {
+		for status := range statusCh {
+			for _, v := range status.Vertexes {
+				dr.logInfo(session.ID, fmt.Sprintf("build: %s", v.Name))
+			}
+			for _, l := range status.Logs {
+				dr.logInfo(session.ID, fmt.Sprintf("build: %s", string(l.Data)))
+			}
+		}
+	}()
+
+	go func() {
+		_, err := bk.Solve(ctx, nil, solveOpt, statusCh)
+		done <- err
+	}()
+
+	if err := <-done; err != nil {
+		return "", fmt.Errorf("buildkit solve failed: %v", err)
+	}
+
+	return tag, nil
+}
+
+// cacheDir namespaces BuildKit's local cache export by workspace so
+// re-running the same workspace reuses npm/pip caches instead of cold
+// starting every session.
+func (dr *DockerRunner) cacheDir(workspaceCacheKey string) string {
+	return filepath.Join(os.TempDir(), "shadow-buildkit-cache", workspaceCacheKey)
+}
+
+func workspaceCacheKey(workspacePath string) string {
+	h := fnv.New64a()
+	h.Write([]byte(workspacePath))
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// RemoveSessionImage deletes the image a session built, if any. Intended
+// to be wired as SessionStore.OnExpire so images don't outlive their TTL.
+func (dr *DockerRunner) RemoveSessionImage(sessionID string) {
+	ctx := context.Background()
+	tag := fmt.Sprintf("shadow-session:%s", sessionID)
+
+	if _, err := dr.client.ImageRemove(ctx, tag, types.ImageRemoveOptions{Force: true}); err != nil {
+		dr.logError(sessionID, fmt.Sprintf("failed to remove session image %s: %v", tag, err))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"os"
@@ -28,6 +29,9 @@ type DockerRunner struct {
 	mutex        sync.RWMutex
 	logStreams   map[string][]chan LogEntry
 	logMutex     sync.RWMutex
+	// seccompProfile is the path to a default seccomp profile applied to
+	// non-root session containers. Empty means Docker's built-in default.
+	seccompProfile string
 }
 
 type RunningContainer struct {
@@ -86,8 +90,15 @@ func (dr *DockerRunner) ExecuteSession(session *Session, command string, environ
 	}
 	defer os.RemoveAll(tempDir)
 
+	// Build a session-specific image if the session carries a Dockerfile,
+	// otherwise fall back to the shared base image.
+	image, err := dr.buildSessionImage(session, tempDir)
+	if err != nil {
+		return fmt.Errorf("failed to build session image: %v", err)
+	}
+
 	// Create and start container
-	containerID, err := dr.createContainer(session.ID, tempDir, command, environment)
+	containerID, err := dr.createContainer(session, image, tempDir, command, environment)
 	if err != nil {
 		return fmt.Errorf("failed to create container: %v", err)
 	}
@@ -130,7 +141,7 @@ func (dr *DockerRunner) prepareSessionFiles(session *Session) (string, error) {
 	}
 
 	// Write session state files
-	for filename, content := range session.State {
+	for filename, content := range session.State.Snapshot() {
 		if contentStr, ok := content.(string); ok {
 			filePath := filepath.Join(tempDir, filename)
 			
@@ -149,18 +160,20 @@ func (dr *DockerRunner) prepareSessionFiles(session *Session) (string, error) {
 	return tempDir, nil
 }
 
-func (dr *DockerRunner) createContainer(sessionID, workDir, command string, environment []string) (string, error) {
+func (dr *DockerRunner) createContainer(session *Session, image, workDir, command string, environment []string) (string, error) {
 	ctx := context.Background()
+	sessionID := session.ID
+	limits := session.Limits
 
 	// Prepare environment variables
-	env := append(environment, 
+	env := append(environment,
 		"NODE_ENV=test",
 		fmt.Sprintf("SHADOW_SESSION_ID=%s", sessionID),
 	)
 
 	// Container configuration
 	config := &container.Config{
-		Image:        dr.baseImage,
+		Image:        image,
 		Cmd:          []string{"sh", "-c", command},
 		Env:          env,
 		WorkingDir:   "/workspace",
@@ -168,17 +181,47 @@ func (dr *DockerRunner) createContainer(sessionID, workDir, command string, envi
 		AttachStderr: true,
 	}
 
-	hostConfig := &container.HostConfig{
-		Mounts: []mount.Mount{
-			{
-				Type:   mount.TypeBind,
-				Source: workDir,
-				Target: "/workspace",
-			},
+	mounts := []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: workDir,
+			Target: "/workspace",
 		},
+	}
+
+	hostConfig := &container.HostConfig{
 		AutoRemove: false, // We'll remove manually after cleanup delay
+		Resources: container.Resources{
+			Memory:    limits.MemoryBytes,
+			NanoCPUs:  limits.NanoCPUs,
+			PidsLimit: pidsLimitPtr(limits.PidsLimit),
+		},
 	}
 
+	if !session.RunAsRoot {
+		uid, gid, passwdPath, groupPath, err := dr.prepareUserFiles(sessionID, workDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to prepare non-root user files: %v", err)
+		}
+
+		if err := chownWorkspace(workDir, uid, gid); err != nil {
+			return "", fmt.Errorf("failed to chown workspace: %v", err)
+		}
+
+		config.User = fmt.Sprintf("%d:%d", uid, gid)
+		mounts = append(mounts,
+			mount.Mount{Type: mount.TypeBind, Source: passwdPath, Target: "/etc/passwd", ReadOnly: true},
+			mount.Mount{Type: mount.TypeBind, Source: groupPath, Target: "/etc/group", ReadOnly: true},
+		)
+
+		hostConfig.SecurityOpt = []string{"no-new-privileges"}
+		if dr.seccompProfile != "" {
+			hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, fmt.Sprintf("seccomp=%s", dr.seccompProfile))
+		}
+	}
+
+	hostConfig.Mounts = mounts
+
 	resp, err := dr.client.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
 	if err != nil {
 		return "", err
@@ -187,6 +230,54 @@ func (dr *DockerRunner) createContainer(sessionID, workDir, command string, envi
 	return resp.ID, nil
 }
 
+// chownWorkspace recursively chowns workDir to uid:gid so the non-root
+// container user can write files that were already on disk (e.g. the
+// session's own state files from prepareSessionFiles), not just ones it
+// creates itself.
+func chownWorkspace(workDir string, uid, gid int) error {
+	return filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chown(path, uid, gid)
+	})
+}
+
+// pidsLimitPtr returns nil for a zero limit so Docker treats it as
+// unlimited, matching how the rest of container.Resources is expressed.
+func pidsLimitPtr(limit int64) *int64 {
+	if limit == 0 {
+		return nil
+	}
+	return &limit
+}
+
+// prepareUserFiles writes an ephemeral /etc/passwd and /etc/group into
+// workDir containing a single non-root entry, modeled on the wings
+// pattern: the uid is derived deterministically from the session ID so
+// repeated runs of the same session keep owning the same workspace files.
+func (dr *DockerRunner) prepareUserFiles(sessionID, workDir string) (uid, gid int, passwdPath, groupPath string, err error) {
+	h := fnv.New32a()
+	h.Write([]byte(sessionID))
+	uid = 50000 + int(h.Sum32()%10000)
+	gid = uid
+
+	passwd := fmt.Sprintf("root:x:0:0:root:/root:/bin/sh\nshadow:x:%d:%d:shadow session:/workspace:/bin/sh\n", uid, gid)
+	group := fmt.Sprintf("root:x:0:\nshadow:x:%d:\n", gid)
+
+	passwdPath = filepath.Join(workDir, ".shadow-passwd")
+	groupPath = filepath.Join(workDir, ".shadow-group")
+
+	if err = os.WriteFile(passwdPath, []byte(passwd), 0644); err != nil {
+		return 0, 0, "", "", err
+	}
+	if err = os.WriteFile(groupPath, []byte(group), 0644); err != nil {
+		return 0, 0, "", "", err
+	}
+
+	return uid, gid, passwdPath, groupPath, nil
+}
+
 func (dr *DockerRunner) runContainer(containerID, sessionID string) {
 	ctx, cancel := context.WithTimeout(context.Background(), dr.timeout)
 	defer cancel()
@@ -399,6 +490,27 @@ func (dr *DockerRunner) GetRunningContainers() map[string]*RunningContainer {
 	for k, v := range dr.running {
 		result[k] = v
 	}
-	
+
 	return result
+}
+
+// LookupBySession resolves a session ID to its RunningContainer, so callers
+// that only know about sessions (e.g. the Docker API facade) never need to
+// see raw Docker container IDs.
+func (dr *DockerRunner) LookupBySession(sessionID string) (*RunningContainer, error) {
+	dr.mutex.RLock()
+	defer dr.mutex.RUnlock()
+
+	rc, exists := dr.running[sessionID]
+	if !exists {
+		return nil, fmt.Errorf("no running container for session: %s", sessionID)
+	}
+
+	return rc, nil
+}
+
+// Client exposes the underlying Docker client so the API facade can
+// delegate requests it doesn't need to translate itself.
+func (dr *DockerRunner) Client() *client.Client {
+	return dr.client
 }
\ No newline at end of file
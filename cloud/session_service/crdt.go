@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// CRDTTag uniquely identifies a single write to a key. Tags, not values,
+// are what tombstones track, so two replicas writing the same value at
+// the same instant still converge deterministically.
+type CRDTTag string
+
+func newCRDTTag() CRDTTag {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return CRDTTag(hex.EncodeToString(b))
+}
+
+// CRDTEntry is one tagged write. Key is included so entries can travel
+// standalone in a CRDTOpSet; the in-memory document itself keys entries
+// by Key already.
+type CRDTEntry struct {
+	Key       string      `json:"key"`
+	Tag       CRDTTag     `json:"tag"`
+	Value     interface{} `json:"value"`
+	Timestamp int64       `json:"timestamp"`
+	ReplicaID string      `json:"replica_id"`
+}
+
+// CRDTOpSet is what clients send instead of a plain key/value delta map:
+// the tagged entries they're adding, and the tags (per key) they're
+// observing-and-removing.
+type CRDTOpSet struct {
+	Adds    []CRDTEntry          `json:"adds"`
+	Removes map[string][]CRDTTag `json:"removes"`
+}
+
+// CRDTDocument is an Observed-Remove Map of LWW-Registers: each key holds
+// a set of tagged entries plus a tombstone set of removed tags. A merge
+// of two documents is the union of live entries minus the union of
+// tombstones; the visible value for a key is the live entry with the
+// highest (timestamp, replica ID). Each tombstone is stamped with the
+// document's VectorClock at the moment it was created, so a tombstone can
+// later be dropped once every known replica has caught up past that
+// point (see CompactTombstones).
+type CRDTDocument struct {
+	Entries    map[string]map[CRDTTag]CRDTEntry   `json:"entries"`
+	Tombstones map[string]map[CRDTTag]VectorClock `json:"tombstones"`
+}
+
+func NewCRDTDocument() *CRDTDocument {
+	return &CRDTDocument{
+		Entries:    make(map[string]map[CRDTTag]CRDTEntry),
+		Tombstones: make(map[string]map[CRDTTag]VectorClock),
+	}
+}
+
+func (d *CRDTDocument) tombstone(key string, tag CRDTTag, version VectorClock) {
+	if d.Tombstones[key] == nil {
+		d.Tombstones[key] = make(map[CRDTTag]VectorClock)
+	}
+	if existing, ok := d.Tombstones[key][tag]; ok {
+		version = existing.Merge(version)
+	}
+	d.Tombstones[key][tag] = version
+}
+
+// observedTags returns every tag this document currently holds for key,
+// live or already tombstoned — both Set and Delete tombstone everything
+// they've observed, per the OR-Map rule.
+func (d *CRDTDocument) observedTags(key string) []CRDTTag {
+	tags := make([]CRDTTag, 0, len(d.Entries[key]))
+	for tag := range d.Entries[key] {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Set adds a new tagged entry for key and tombstones every tag
+// previously observed for key, so this write wins once merged against
+// any concurrent-but-older write. version is the document's VectorClock
+// as of this write, stamped onto any tombstones it creates.
+func (d *CRDTDocument) Set(key string, value interface{}, timestamp int64, replicaID string, version VectorClock) CRDTEntry {
+	for _, tag := range d.observedTags(key) {
+		d.tombstone(key, tag, version)
+	}
+
+	entry := CRDTEntry{Key: key, Tag: newCRDTTag(), Value: value, Timestamp: timestamp, ReplicaID: replicaID}
+	if d.Entries[key] == nil {
+		d.Entries[key] = make(map[CRDTTag]CRDTEntry)
+	}
+	d.Entries[key][entry.Tag] = entry
+
+	return entry
+}
+
+// Delete tombstones every tag observed for key without adding a new one,
+// stamped with version the same way Set stamps its tombstones.
+func (d *CRDTDocument) Delete(key string, version VectorClock) {
+	for _, tag := range d.observedTags(key) {
+		d.tombstone(key, tag, version)
+	}
+}
+
+// ReplaceAll tombstones every currently observed key (so keys absent
+// from state really disappear) and then Sets every key in state, which
+// is what a full snapshot sync means for a CRDT document.
+func (d *CRDTDocument) ReplaceAll(state map[string]interface{}, timestamp int64, replicaID string, version VectorClock) {
+	for key := range d.Entries {
+		d.Delete(key, version)
+	}
+	for key, value := range state {
+		d.Set(key, value, timestamp, replicaID, version)
+	}
+}
+
+// Get returns the live value for key: the surviving entry with the
+// highest (timestamp, replica ID), or ok=false if every entry for key
+// has been tombstoned or the key was never set.
+func (d *CRDTDocument) Get(key string) (interface{}, bool) {
+	var winner *CRDTEntry
+	for tag, entry := range d.Entries[key] {
+		if _, tombstoned := d.Tombstones[key][tag]; tombstoned {
+			continue
+		}
+		e := entry
+		if winner == nil || isNewerEntry(e, *winner) {
+			winner = &e
+		}
+	}
+	if winner == nil {
+		return nil, false
+	}
+	return winner.Value, true
+}
+
+func isNewerEntry(a, b CRDTEntry) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp > b.Timestamp
+	}
+	return a.ReplicaID > b.ReplicaID
+}
+
+// Snapshot materializes every live key/value as a plain map, for callers
+// (e.g. writing session files into a container) that just want values.
+func (d *CRDTDocument) Snapshot() map[string]interface{} {
+	out := make(map[string]interface{})
+	for key := range d.Entries {
+		if v, ok := d.Get(key); ok {
+			out[key] = v
+		}
+	}
+	return out
+}
+
+// ApplyOpSet merges a client-submitted op-set into the document. version
+// is the document's VectorClock as of this op-set, stamped onto any
+// tombstones the Removes create.
+func (d *CRDTDocument) ApplyOpSet(ops CRDTOpSet, version VectorClock) {
+	for _, entry := range ops.Adds {
+		if d.Entries[entry.Key] == nil {
+			d.Entries[entry.Key] = make(map[CRDTTag]CRDTEntry)
+		}
+		d.Entries[entry.Key][entry.Tag] = entry
+	}
+	for key, tags := range ops.Removes {
+		for _, tag := range tags {
+			d.tombstone(key, tag, version)
+		}
+	}
+}
+
+// Merge folds another replica's document into this one: entries union,
+// tombstones union (keeping the component-wise max of both sides'
+// stamped versions for any tag tombstoned on both). Used when replicas
+// exchange full documents rather than incremental op-sets.
+func (d *CRDTDocument) Merge(other *CRDTDocument) {
+	for key, tags := range other.Entries {
+		for tag, entry := range tags {
+			if d.Entries[key] == nil {
+				d.Entries[key] = make(map[CRDTTag]CRDTEntry)
+			}
+			d.Entries[key][tag] = entry
+		}
+	}
+	for key, tags := range other.Tombstones {
+		for tag, version := range tags {
+			d.tombstone(key, tag, version)
+		}
+	}
+}
+
+// CompactTombstones physically drops tombstoned entries (and the
+// tombstone markers themselves) once every replica in acks has a
+// VectorClock that dominates the version the tombstone was stamped
+// with — i.e. every known replica has already merged past the write
+// that tombstone superseded, so nothing still needs it to converge.
+// acks is keyed by replica ID; a tag whose stamped version isn't
+// dominated by all of acks (or if acks is empty, meaning no replica's
+// progress is known yet) is left alone. Returns the number of tags
+// compacted, for callers that want to log it.
+func (d *CRDTDocument) CompactTombstones(acks map[string]VectorClock) int {
+	if len(acks) == 0 {
+		return 0
+	}
+
+	compacted := 0
+	for key, tagSet := range d.Tombstones {
+		for tag, version := range tagSet {
+			acknowledged := true
+			for _, ack := range acks {
+				if !ack.Dominates(version) {
+					acknowledged = false
+					break
+				}
+			}
+			if !acknowledged {
+				continue
+			}
+
+			if entries, ok := d.Entries[key]; ok {
+				delete(entries, tag)
+				if len(entries) == 0 {
+					delete(d.Entries, key)
+				}
+			}
+			delete(tagSet, tag)
+			compacted++
+		}
+		if len(tagSet) == 0 {
+			delete(d.Tombstones, key)
+		}
+	}
+
+	return compacted
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// SessionEncryptor wraps a Session in an AES-GCM envelope before it
+// reaches a persistent repository, so Metadata and State are encrypted
+// at rest regardless of which backend (BoltDB, Redis) is configured.
+// masterKey must be 16, 24, or 32 bytes (AES-128/192/256).
+type SessionEncryptor struct {
+	masterKey []byte
+}
+
+func NewSessionEncryptor(masterKey []byte) (*SessionEncryptor, error) {
+	if _, err := aes.NewCipher(masterKey); err != nil {
+		return nil, fmt.Errorf("invalid encryption master key: %v", err)
+	}
+	return &SessionEncryptor{masterKey: masterKey}, nil
+}
+
+// Seal JSON-encodes the session and returns nonce||ciphertext.
+func (e *SessionEncryptor) Seal(session *Session) ([]byte, error) {
+	plaintext, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := e.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open reverses Seal.
+func (e *SessionEncryptor) Open(data []byte) (*Session, error) {
+	gcm, err := e.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted session payload too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt session: %v", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(plaintext, &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (e *SessionEncryptor) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(e.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const lockRefreshInterval = 5 * time.Second
+
+// stateLockResource is the resource name SyncSession/UpdateSessionStatus
+// check against, so a client that has taken an explicit write lock on a
+// session's state (via AcquireLock) is actually guaranteed exclusivity
+// against other replicas' syncs, not just against other lock holders.
+const stateLockResource = "state"
+
+// LockToken is handed back from an Acquire call and is what callers must
+// present to refresh or release the lock. It embeds a random per-
+// acquisition ID so a stale or forged token can't be used to refresh or
+// release a lock some other owner currently holds.
+type LockToken string
+
+func newLockToken() LockToken {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return LockToken(hex.EncodeToString(b))
+}
+
+// LockEntry describes one held lock, modeled on the MinIO dsync
+// refresh/owner/quorum work: Owner identifies who asked for the lock,
+// Source is the caller's own identity string (e.g. a hostname or
+// request ID) for debugging, and UID is this specific acquisition's
+// random ID (equal to Token) so a release can be checked against the
+// exact grant rather than just the owner name.
+type LockEntry struct {
+	Token       LockToken `json:"token"`
+	SessionID   string    `json:"session_id"`
+	Resource    string    `json:"resource"`
+	Owner       string    `json:"owner"`
+	Source      string    `json:"source"`
+	UID         string    `json:"uid"`
+	Timestamp   time.Time `json:"timestamp"`
+	Writer      bool      `json:"writer"`
+	ttl         time.Duration
+	lastRefresh time.Time
+}
+
+func (e *LockEntry) stale(now time.Time) bool {
+	return now.Sub(e.lastRefresh) > e.ttl
+}
+
+type lockedResource struct {
+	writer  *LockEntry
+	readers map[LockToken]*LockEntry
+}
+
+func resourceKey(sessionID, resource string) string {
+	return sessionID + "/" + resource
+}
+
+// LockManager is an explicit locking subsystem for session resources.
+// SyncSession and UpdateSessionStatus otherwise race freely across
+// concurrent Shadow clients editing the same workspace; callers that
+// need exclusivity take a lock here first. This is a single-node
+// manager (quorum is always 1 of 1) — a real multi-node deployment would
+// need to collect acks from a quorum of peer lock servers the way MinIO
+// dsync does, which this does not attempt.
+type LockManager struct {
+	mu        sync.Mutex
+	resources map[string]*lockedResource
+	tokens    map[LockToken]string // token -> resource key, for O(1) release/refresh lookup
+
+	shutdown chan struct{}
+}
+
+func NewLockManager() *LockManager {
+	lm := &LockManager{
+		resources: make(map[string]*lockedResource),
+		tokens:    make(map[LockToken]string),
+		shutdown:  make(chan struct{}),
+	}
+	go lm.refreshLoop()
+	return lm
+}
+
+func (lm *LockManager) Stop() {
+	close(lm.shutdown)
+}
+
+func (lm *LockManager) refreshLoop() {
+	ticker := time.NewTicker(lockRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lm.reapStale()
+		case <-lm.shutdown:
+			return
+		}
+	}
+}
+
+func (lm *LockManager) reapStale() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := time.Now()
+	for key, res := range lm.resources {
+		if res.writer != nil && res.writer.stale(now) {
+			delete(lm.tokens, res.writer.Token)
+			res.writer = nil
+		}
+		for token, entry := range res.readers {
+			if entry.stale(now) {
+				delete(res.readers, token)
+				delete(lm.tokens, token)
+			}
+		}
+		if res.writer == nil && len(res.readers) == 0 {
+			delete(lm.resources, key)
+		}
+	}
+}
+
+// AcquireLock takes an exclusive (writer) lock on resource within
+// sessionID. It fails if any writer or reader currently holds it.
+func (lm *LockManager) AcquireLock(sessionID, resource, owner, source string, ttl time.Duration) (LockToken, error) {
+	return lm.acquire(sessionID, resource, owner, source, ttl, true)
+}
+
+// AcquireReadLock takes a shared (reader) lock: it can coexist with
+// other readers but fails if a writer currently holds the resource.
+func (lm *LockManager) AcquireReadLock(sessionID, resource, owner, source string, ttl time.Duration) (LockToken, error) {
+	return lm.acquire(sessionID, resource, owner, source, ttl, false)
+}
+
+func (lm *LockManager) acquire(sessionID, resource, owner, source string, ttl time.Duration, writer bool) (LockToken, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	key := resourceKey(sessionID, resource)
+	res, ok := lm.resources[key]
+	if !ok {
+		res = &lockedResource{readers: make(map[LockToken]*LockEntry)}
+		lm.resources[key] = res
+	}
+
+	if res.writer != nil {
+		return "", fmt.Errorf("resource %s is write-locked by %s", resource, res.writer.Owner)
+	}
+	if writer && len(res.readers) > 0 {
+		return "", fmt.Errorf("resource %s is read-locked", resource)
+	}
+
+	now := time.Now()
+	token := newLockToken()
+	entry := &LockEntry{
+		Token:       token,
+		SessionID:   sessionID,
+		Resource:    resource,
+		Owner:       owner,
+		Source:      source,
+		UID:         string(token),
+		Timestamp:   now,
+		Writer:      writer,
+		ttl:         ttl,
+		lastRefresh: now,
+	}
+
+	if writer {
+		res.writer = entry
+	} else {
+		res.readers[token] = entry
+	}
+	lm.tokens[token] = key
+
+	return token, nil
+}
+
+func (lm *LockManager) lookup(token LockToken) (key string, entry *LockEntry, err error) {
+	key, ok := lm.tokens[token]
+	if !ok {
+		return "", nil, fmt.Errorf("lock not found")
+	}
+
+	res := lm.resources[key]
+	if res.writer != nil && res.writer.Token == token {
+		return key, res.writer, nil
+	}
+	if entry, ok := res.readers[token]; ok {
+		return key, entry, nil
+	}
+
+	return "", nil, fmt.Errorf("lock not found")
+}
+
+// RefreshLock extends the lease on a held lock. owner and uid must match
+// the values recorded at acquisition time.
+func (lm *LockManager) RefreshLock(token LockToken, owner, uid string) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	_, entry, err := lm.lookup(token)
+	if err != nil {
+		return err
+	}
+	if entry.Owner != owner || entry.UID != uid {
+		return fmt.Errorf("lock owner mismatch")
+	}
+
+	entry.lastRefresh = time.Now()
+	return nil
+}
+
+// ReleaseLock releases a held lock. Verifying owner/uid against the
+// recorded grant prevents one caller from releasing a lock it doesn't
+// actually hold.
+func (lm *LockManager) ReleaseLock(token LockToken, owner, uid string) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	key, entry, err := lm.lookup(token)
+	if err != nil {
+		return err
+	}
+	if entry.Owner != owner || entry.UID != uid {
+		return fmt.Errorf("lock owner mismatch")
+	}
+
+	res := lm.resources[key]
+	if entry.Writer {
+		res.writer = nil
+	} else {
+		delete(res.readers, token)
+	}
+	delete(lm.tokens, token)
+
+	if res.writer == nil && len(res.readers) == 0 {
+		delete(lm.resources, key)
+	}
+
+	return nil
+}
+
+// TopLocks returns up to count currently-held locks, most recently
+// acquired first, for admin/debug inspection. When includeStale is
+// true, locks the refresher is about to reap (past their lease without
+// a refresh) are included too; otherwise they're filtered out.
+func (lm *LockManager) TopLocks(count int, includeStale bool) []LockEntry {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := time.Now()
+	var entries []LockEntry
+
+	collect := func(e *LockEntry) {
+		if e == nil {
+			return
+		}
+		if !includeStale && e.stale(now) {
+			return
+		}
+		entries = append(entries, *e)
+	}
+
+	for _, res := range lm.resources {
+		collect(res.writer)
+		for _, entry := range res.readers {
+			collect(entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Timestamp.After(entries[j].Timestamp)
+	})
+
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+
+	return entries
+}
+
+// HeldByOther reports whether resource is currently write-locked by an
+// owner other than the given one. A lock the refresher is about to reap
+// (stale) doesn't count, so an abandoned lock can't wedge every future
+// sync for a session.
+func (lm *LockManager) HeldByOther(sessionID, resource, owner string) bool {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	res, ok := lm.resources[resourceKey(sessionID, resource)]
+	if !ok || res.writer == nil || res.writer.stale(time.Now()) {
+		return false
+	}
+
+	return res.writer.Owner != owner
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for deriving a session's auth hash from its
+// secret. These are the values of golang.org/x/crypto/argon2's own IDKey
+// example (time=1, 64 MiB, 4 threads, 32-byte key).
+const (
+	argonTime    = 1
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	argonKeyLen  = 32
+
+	authSaltLen   = 16
+	authSecretLen = 32
+)
+
+// errInvalidSessionToken is returned for every way a presented token can
+// fail to authenticate — malformed encoding, unknown session ID, expired
+// session, or wrong secret — so a caller can't use the error to probe
+// which sessions exist.
+var errInvalidSessionToken = fmt.Errorf("session not found")
+
+func newSessionSecret() (string, error) {
+	b := make([]byte, authSecretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func newAuthSalt() ([]byte, error) {
+	salt := make([]byte, authSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func deriveAuthHash(secret string, salt []byte) []byte {
+	return argon2.IDKey([]byte(secret), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+}
+
+// encodeSessionToken is the token handed to clients in place of a raw
+// session ID: base64(sessionID + "." + secret).
+func encodeSessionToken(sessionID, secret string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sessionID + "." + secret))
+}
+
+func decodeSessionToken(token string) (sessionID, secret string, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", errInvalidSessionToken
+	}
+
+	parts := strings.SplitN(string(raw), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errInvalidSessionToken
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// Authenticate decodes token into a session ID and secret, looks up the
+// session, and re-derives the Argon2id hash to compare against the one
+// stored on the session in constant time. Every failure path returns
+// errInvalidSessionToken so the caller learns nothing about why.
+func (s *SessionStore) Authenticate(token string) (*Session, error) {
+	sessionID, secret, err := decodeSessionToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.getSessionByID(sessionID)
+	if err != nil {
+		return nil, errInvalidSessionToken
+	}
+
+	candidate := deriveAuthHash(secret, session.AuthSalt)
+	if subtle.ConstantTimeCompare(candidate, session.AuthHash) != 1 {
+		return nil, errInvalidSessionToken
+	}
+
+	return session, nil
+}
+
+// RotateSecret generates a fresh secret and auth hash for sessionID,
+// invalidating every token issued before the rotation, and returns the
+// new token.
+func (s *SessionStore) RotateSecret(sessionID string) (string, error) {
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+
+	session, err := s.repo.Get(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	secret, err := newSessionSecret()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate session secret: %v", err)
+	}
+	salt, err := newAuthSalt()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate auth salt: %v", err)
+	}
+
+	session.AuthSalt = salt
+	session.AuthHash = deriveAuthHash(secret, salt)
+
+	if err := s.repo.Update(session); err != nil {
+		return "", err
+	}
+
+	return encodeSessionToken(sessionID, secret), nil
+}
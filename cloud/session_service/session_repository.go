@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SessionRepository is the persistence boundary SessionStore talks to.
+// Swapping implementations (in-memory, BoltDB, Redis) changes durability
+// without touching session logic, TTL handling, or the sync/locking
+// subsystems built on top of SessionStore.
+type SessionRepository interface {
+	Create(session *Session) error
+	Get(id string) (*Session, error)
+	Update(session *Session) error
+	GetAndDelete(id string) (*Session, error)
+	Delete(id string) error
+	List() ([]*Session, error)
+	// GC removes every session whose ExpiresAt is before now and returns
+	// the IDs it removed.
+	GC(now time.Time) ([]string, error)
+}
+
+// memoryRepository is the original in-memory map. It's the default
+// backend and the reference implementation other repositories are
+// checked against.
+type memoryRepository struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewMemoryRepository returns a SessionRepository backed by a plain map
+// that vanishes on process restart.
+func NewMemoryRepository() SessionRepository {
+	return &memoryRepository{sessions: make(map[string]*Session)}
+}
+
+// cloneSession deep-copies a session via a JSON round-trip, the same
+// technique SessionEncryptor.Seal/Open already give the Bolt/Redis
+// repositories for free. memoryRepository has to do it explicitly:
+// without it, Get/List would hand out the exact pointer living in the
+// map, so a caller reading it (e.g. handleSessionGet's shallow copy, or
+// json.Marshal inside an HTTP handler) could race a concurrent
+// mutation's writes to session.State.Entries/Metadata/ReplicaAcks —
+// the Go runtime kills the process on a concurrent map read/write, not
+// just silently corrupts data.
+func cloneSession(session *Session) (*Session, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone Session
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, err
+	}
+
+	return &clone, nil
+}
+
+func (r *memoryRepository) Create(session *Session) error {
+	clone, err := cloneSession(session)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[session.ID] = clone
+	return nil
+}
+
+func (r *memoryRepository) Get(id string) (*Session, error) {
+	r.mu.RLock()
+	session, ok := r.sessions[id]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("session not found: %s", id)
+	}
+	return cloneSession(session)
+}
+
+func (r *memoryRepository) Update(session *Session) error {
+	clone, err := cloneSession(session)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sessions[session.ID]; !ok {
+		return fmt.Errorf("session not found: %s", session.ID)
+	}
+	r.sessions[session.ID] = clone
+	return nil
+}
+
+func (r *memoryRepository) GetAndDelete(id string) (*Session, error) {
+	r.mu.Lock()
+	session, ok := r.sessions[id]
+	if ok {
+		delete(r.sessions, id)
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("session not found: %s", id)
+	}
+	return cloneSession(session)
+}
+
+func (r *memoryRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, id)
+	return nil
+}
+
+func (r *memoryRepository) List() ([]*Session, error) {
+	r.mu.RLock()
+	snapshot := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		snapshot = append(snapshot, session)
+	}
+	r.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(snapshot))
+	for _, session := range snapshot {
+		clone, err := cloneSession(session)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, clone)
+	}
+	return sessions, nil
+}
+
+func (r *memoryRepository) GC(now time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired []string
+	for id, session := range r.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(r.sessions, id)
+			expired = append(expired, id)
+		}
+	}
+	return expired, nil
+}
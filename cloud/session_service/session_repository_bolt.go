@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var sessionsBucket = []byte("sessions")
+
+// boltRepository persists sessions to a local BoltDB file, encrypted at
+// rest via the configured SessionEncryptor, so sessions survive process
+// restarts on a single node.
+type boltRepository struct {
+	db        *bolt.DB
+	encryptor *SessionEncryptor
+}
+
+func NewBoltRepository(path string, encryptor *SessionEncryptor) (SessionRepository, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt db: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sessions bucket: %v", err)
+	}
+
+	return &boltRepository{db: db, encryptor: encryptor}, nil
+}
+
+func (r *boltRepository) Create(session *Session) error {
+	return r.put(session)
+}
+
+func (r *boltRepository) Update(session *Session) error {
+	return r.put(session)
+}
+
+func (r *boltRepository) put(session *Session) error {
+	data, err := r.encryptor.Seal(session)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(session.ID), data)
+	})
+}
+
+func (r *boltRepository) Get(id string) (*Session, error) {
+	var session *Session
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("session not found: %s", id)
+		}
+
+		decoded, err := r.encryptor.Open(data)
+		if err != nil {
+			return err
+		}
+		session = decoded
+		return nil
+	})
+
+	return session, err
+}
+
+func (r *boltRepository) GetAndDelete(id string) (*Session, error) {
+	session, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Delete(id); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *boltRepository) Delete(id string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
+
+func (r *boltRepository) List() ([]*Session, error) {
+	var sessions []*Session
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			session, err := r.encryptor.Open(v)
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, session)
+			return nil
+		})
+	})
+
+	return sessions, err
+}
+
+func (r *boltRepository) GC(now time.Time) ([]string, error) {
+	sessions, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []string
+	for _, session := range sessions {
+		if now.After(session.ExpiresAt) {
+			expired = append(expired, session.ID)
+		}
+	}
+
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		for _, id := range expired {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return expired, err
+}
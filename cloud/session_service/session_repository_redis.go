@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "shadow:session:"
+
+// redisRepository persists sessions in Redis, encrypted at rest, so
+// multiple API Gateway replicas can share the same session state instead
+// of each replica holding its own in-memory copy.
+type redisRepository struct {
+	client    *redis.Client
+	encryptor *SessionEncryptor
+}
+
+func NewRedisRepository(addr string, encryptor *SessionEncryptor) SessionRepository {
+	return &redisRepository{
+		client:    redis.NewClient(&redis.Options{Addr: addr}),
+		encryptor: encryptor,
+	}
+}
+
+func redisKey(id string) string {
+	return redisKeyPrefix + id
+}
+
+func (r *redisRepository) Create(session *Session) error {
+	return r.put(session)
+}
+
+func (r *redisRepository) Update(session *Session) error {
+	return r.put(session)
+}
+
+func (r *redisRepository) put(session *Session) error {
+	data, err := r.encryptor.Seal(session)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(context.Background(), redisKey(session.ID), data, 0).Err()
+}
+
+func (r *redisRepository) Get(id string) (*Session, error) {
+	data, err := r.client.Get(context.Background(), redisKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("session not found: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.encryptor.Open(data)
+}
+
+func (r *redisRepository) GetAndDelete(id string) (*Session, error) {
+	session, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Delete(id); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *redisRepository) Delete(id string) error {
+	return r.client.Del(context.Background(), redisKey(id)).Err()
+}
+
+func (r *redisRepository) List() ([]*Session, error) {
+	ctx := context.Background()
+
+	keys, err := r.client.Keys(ctx, redisKeyPrefix+"*").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*Session
+	for _, key := range keys {
+		data, err := r.client.Get(ctx, key).Bytes()
+		if err != nil {
+			continue
+		}
+		session, err := r.encryptor.Open(data)
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func (r *redisRepository) GC(now time.Time) ([]string, error) {
+	sessions, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []string
+	for _, session := range sessions {
+		if now.After(session.ExpiresAt) {
+			if err := r.Delete(session.ID); err != nil {
+				continue
+			}
+			expired = append(expired, session.ID)
+		}
+	}
+
+	return expired, nil
+}
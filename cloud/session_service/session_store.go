@@ -8,43 +8,133 @@ import (
 	"encoding/hex"
 )
 
+// serverReplicaID identifies the central SessionStore's own writes (e.g.
+// status updates) in a session's vector clock, distinct from the
+// replica IDs Shadow clients use for their own sync ops.
+const serverReplicaID = "server"
+
 type Session struct {
-	ID            string                 `json:"id"`
-	WorkspacePath string                 `json:"workspace_path"`
-	Metadata      map[string]string      `json:"metadata"`
-	State         map[string]interface{} `json:"state"`
-	Status        string                 `json:"status"`
-	CreatedAt     time.Time              `json:"created_at"`
-	UpdatedAt     time.Time              `json:"updated_at"`
-	ExpiresAt     time.Time              `json:"expires_at"`
-	Version       int                    `json:"version"`
+	ID            string            `json:"id"`
+	WorkspacePath string            `json:"workspace_path"`
+	Metadata      map[string]string `json:"metadata"`
+	State         *CRDTDocument     `json:"state"`
+	Status        string            `json:"status"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	ExpiresAt     time.Time         `json:"expires_at"`
+	Version       VectorClock       `json:"version"`
+	Limits        SessionLimits     `json:"limits"`
+	Dockerfile    string            `json:"dockerfile,omitempty"`
+	BuildContext  map[string]string `json:"build_context,omitempty"`
+	// ReplicaAcks records, per replica ID, the VectorClock that replica is
+	// known to have reached as of its last sync. CompactTombstones uses it
+	// to decide when a tombstone is safe to drop — see syncSessionByID.
+	ReplicaAcks map[string]VectorClock `json:"replica_acks,omitempty"`
+	// RunAsRoot opts a session out of the default non-root user isolation.
+	RunAsRoot bool `json:"run_as_root,omitempty"`
+	// AuthSalt and AuthHash are the Argon2id salt and derived key for this
+	// session's secret. The secret itself is never stored; it only ever
+	// exists inside the token handed back from CreateSession/RotateSecret.
+	// These are tagged so they round-trip through SessionEncryptor's
+	// at-rest encoding; callers that hand a Session back over the API
+	// must strip them first (see handleSessionGet).
+	AuthSalt []byte `json:"auth_salt,omitempty"`
+	AuthHash []byte `json:"auth_hash,omitempty"`
+}
+
+// SessionLimits caps the resources a session's container may consume.
+// Zero values mean "no limit", matching Docker's own Resources struct.
+type SessionLimits struct {
+	MemoryBytes int64 `json:"memory_bytes"`
+	NanoCPUs    int64 `json:"nano_cpus"`
+	PidsLimit   int64 `json:"pids_limit"`
 }
 
+// SessionStore owns session lifecycle (TTL, status, sync) on top of a
+// pluggable SessionRepository. Session mutation goes through storeMutex
+// rather than relying on the repository for read-modify-write atomicity,
+// since not every backend (Redis) offers that natively.
 type SessionStore struct {
-	sessions map[string]*Session
-	mutex    sync.RWMutex
-	ttl      time.Duration
+	repo       SessionRepository
+	storeMutex sync.Mutex
+	ttl        time.Duration
+	gcInterval time.Duration
+	// OnExpire, if set, is called for every session removed by the TTL
+	// reaper so owners of per-session resources (e.g. built Docker images)
+	// can clean up without SessionStore knowing about them.
+	OnExpire func(sessionID string)
+
+	locks *LockManager
 }
 
-func NewSessionStore() *SessionStore {
+// NewSessionStore wires a SessionStore to the given repository. ttl and
+// gcInterval of zero fall back to the previous hardcoded defaults (72h
+// TTL, hourly GC) so existing callers don't need to change.
+func NewSessionStore(repo SessionRepository, ttl, gcInterval time.Duration) *SessionStore {
+	if ttl <= 0 {
+		ttl = 72 * time.Hour
+	}
+	if gcInterval <= 0 {
+		gcInterval = 1 * time.Hour
+	}
+
 	store := &SessionStore{
-		sessions: make(map[string]*Session),
-		ttl:      72 * time.Hour, // Default TTL
+		repo:       repo,
+		ttl:        ttl,
+		gcInterval: gcInterval,
+		locks:      NewLockManager(),
 	}
-	
-	// Start cleanup goroutine
+
 	go store.cleanupExpiredSessions()
-	
+
 	return store
 }
 
-func (s *SessionStore) CreateSession(workspacePath string, metadata map[string]string) (*Session, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// AcquireLock takes an exclusive lock on resource within sessionID. owner
+// identifies the caller and source is the caller's own identity string
+// (e.g. a hostname or request ID) recorded for admin/debug purposes.
+func (s *SessionStore) AcquireLock(sessionID, resource, owner, source string, ttl time.Duration) (LockToken, error) {
+	return s.locks.AcquireLock(sessionID, resource, owner, source, ttl)
+}
+
+// AcquireReadLock takes a shared lock on resource within sessionID.
+func (s *SessionStore) AcquireReadLock(sessionID, resource, owner, source string, ttl time.Duration) (LockToken, error) {
+	return s.locks.AcquireReadLock(sessionID, resource, owner, source, ttl)
+}
+
+// RefreshLock extends the lease on a previously acquired lock.
+func (s *SessionStore) RefreshLock(token LockToken, owner, uid string) error {
+	return s.locks.RefreshLock(token, owner, uid)
+}
+
+// ReleaseLock releases a previously acquired lock.
+func (s *SessionStore) ReleaseLock(token LockToken, owner, uid string) error {
+	return s.locks.ReleaseLock(token, owner, uid)
+}
+
+// TopLocks exposes currently-held session locks for admin/debug endpoints.
+func (s *SessionStore) TopLocks(count int, includeStale bool) []LockEntry {
+	return s.locks.TopLocks(count, includeStale)
+}
 
+// CreateSession creates a new session and returns it along with the
+// bearer token the caller must present to every subsequent token-gated
+// call (GetSession, SyncSession, UpdateSessionStatus, DeleteSession).
+// Only the token's Argon2id hash is stored on the session; the token
+// itself is never persisted or recoverable after this call returns.
+func (s *SessionStore) CreateSession(workspacePath string, metadata map[string]string, limits SessionLimits, dockerfile string, buildContext map[string]string, runAsRoot bool) (*Session, string, error) {
 	sessionID, err := generateSessionID()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate session ID: %v", err)
+		return nil, "", fmt.Errorf("failed to generate session ID: %v", err)
+	}
+
+	secret, err := newSessionSecret()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate session secret: %v", err)
+	}
+	salt, err := newAuthSalt()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate auth salt: %v", err)
 	}
 
 	now := time.Now()
@@ -52,24 +142,38 @@ func (s *SessionStore) CreateSession(workspacePath string, metadata map[string]s
 		ID:            sessionID,
 		WorkspacePath: workspacePath,
 		Metadata:      metadata,
-		State:         make(map[string]interface{}),
+		State:         NewCRDTDocument(),
 		Status:        "created",
 		CreatedAt:     now,
 		UpdatedAt:     now,
 		ExpiresAt:     now.Add(s.ttl),
-		Version:       1,
+		Version:       VectorClock{serverReplicaID: 1},
+		Limits:        limits,
+		Dockerfile:    dockerfile,
+		BuildContext:  buildContext,
+		RunAsRoot:     runAsRoot,
+		AuthSalt:      salt,
+		AuthHash:      deriveAuthHash(secret, salt),
 	}
 
-	s.sessions[sessionID] = session
-	return session, nil
+	if err := s.repo.Create(session); err != nil {
+		return nil, "", err
+	}
+
+	return session, encodeSessionToken(sessionID, secret), nil
 }
 
-func (s *SessionStore) GetSession(sessionID string) (*Session, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// GetSession authenticates token and returns the session it names.
+func (s *SessionStore) GetSession(token string) (*Session, error) {
+	return s.Authenticate(token)
+}
 
-	session, exists := s.sessions[sessionID]
-	if !exists {
+// getSessionByID is the trusted, unauthenticated lookup used internally
+// by code that already holds a validated session ID (e.g. the sync
+// worker replaying its own WAL), as opposed to a client-presented token.
+func (s *SessionStore) getSessionByID(sessionID string) (*Session, error) {
+	session, err := s.repo.Get(sessionID)
+	if err != nil {
 		return nil, fmt.Errorf("session not found: %s", sessionID)
 	}
 
@@ -80,12 +184,34 @@ func (s *SessionStore) GetSession(sessionID string) (*Session, error) {
 	return session, nil
 }
 
-func (s *SessionStore) SyncSession(sessionID string, delta map[string]interface{}, snapshot map[string]interface{}) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// SyncSession authenticates token, then merges a client's op-set
+// (adds/removes) into the named session's CRDT document and advances
+// that client's entry in the vector clock.
+func (s *SessionStore) SyncSession(token, replicaID string, ops CRDTOpSet) error {
+	session, err := s.Authenticate(token)
+	if err != nil {
+		return err
+	}
+
+	return s.syncSessionByID(session.ID, replicaID, ops)
+}
+
+// syncSessionByID is the trusted, unauthenticated counterpart to
+// SyncSession used internally once a session ID is already known-good
+// (e.g. replaying the sync worker's own WAL). It rejects the write if
+// another replica currently holds the session's "state" write lock, so
+// AcquireLock is an actual precondition for sync exclusivity rather than
+// a side door nothing checks.
+func (s *SessionStore) syncSessionByID(sessionID, replicaID string, ops CRDTOpSet) error {
+	if s.locks.HeldByOther(sessionID, stateLockResource, replicaID) {
+		return fmt.Errorf("session state is locked by another writer")
+	}
+
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
 
-	session, exists := s.sessions[sessionID]
-	if !exists {
+	session, err := s.repo.Get(sessionID)
+	if err != nil {
 		return fmt.Errorf("session not found: %s", sessionID)
 	}
 
@@ -93,80 +219,190 @@ func (s *SessionStore) SyncSession(sessionID string, delta map[string]interface{
 		return fmt.Errorf("session expired: %s", sessionID)
 	}
 
-	// Apply delta or replace with snapshot
-	if snapshot != nil {
-		session.State = snapshot
-	} else if delta != nil {
-		// Simple merge for delta (in production, use proper CRDT)
-		for key, value := range delta {
-			session.State[key] = value
-		}
+	session.Version = session.Version.Increment(replicaID)
+	session.State.ApplyOpSet(ops, session.Version)
+	session.UpdatedAt = time.Now()
+	session.Status = "synced"
+
+	// A replica pushing a sync is, by construction, caught up to the
+	// version that sync produces — record that so CompactTombstones can
+	// eventually drop tombstones every known replica has moved past.
+	if session.ReplicaAcks == nil {
+		session.ReplicaAcks = make(map[string]VectorClock)
+	}
+	session.ReplicaAcks[replicaID] = session.Version
+	session.State.CompactTombstones(session.ReplicaAcks)
+
+	return s.repo.Update(session)
+}
+
+// ReplaceSessionState fully replaces the session's CRDT document, the
+// same way a one-shot snapshot used to, but still going through the
+// document's tombstone/tag bookkeeping so it converges correctly against
+// concurrent deltas from other replicas.
+func (s *SessionStore) ReplaceSessionState(sessionID, replicaID string, state map[string]interface{}) error {
+	if s.locks.HeldByOther(sessionID, stateLockResource, replicaID) {
+		return fmt.Errorf("session state is locked by another writer")
+	}
+
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+
+	session, err := s.repo.Get(sessionID)
+	if err != nil {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return fmt.Errorf("session expired: %s", sessionID)
+	}
+
+	session.Version = session.Version.Increment(replicaID)
+	session.State.ReplaceAll(state, time.Now().UnixNano(), replicaID, session.Version)
+	session.UpdatedAt = time.Now()
+	session.Status = "synced"
+
+	return s.repo.Update(session)
+}
+
+// SetSessionKeys writes each key/value directly through the CRDT
+// document's Set (which tombstones whatever it previously observed for
+// that key), for callers like AtomicSync that apply a patch rather than
+// a client-submitted op-set.
+func (s *SessionStore) SetSessionKeys(sessionID, replicaID string, updates map[string]interface{}) error {
+	if s.locks.HeldByOther(sessionID, stateLockResource, replicaID) {
+		return fmt.Errorf("session state is locked by another writer")
 	}
 
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+
+	session, err := s.repo.Get(sessionID)
+	if err != nil {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return fmt.Errorf("session expired: %s", sessionID)
+	}
+
+	session.Version = session.Version.Increment(replicaID)
+	now := time.Now().UnixNano()
+	for key, value := range updates {
+		session.State.Set(key, value, now, replicaID, session.Version)
+	}
 	session.UpdatedAt = time.Now()
-	session.Version++
 	session.Status = "synced"
 
-	return nil
+	return s.repo.Update(session)
+}
+
+// UpdateSessionStatus authenticates token, then sets the named session's
+// status.
+func (s *SessionStore) UpdateSessionStatus(token string, status string) error {
+	session, err := s.Authenticate(token)
+	if err != nil {
+		return err
+	}
+
+	return s.updateSessionStatusByID(session.ID, status)
 }
 
-func (s *SessionStore) UpdateSessionStatus(sessionID string, status string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// updateSessionStatusByID is the trusted, unauthenticated counterpart to
+// UpdateSessionStatus used internally once a session ID is already
+// known-good (e.g. replaying the sync worker's own WAL).
+func (s *SessionStore) updateSessionStatusByID(sessionID string, status string) error {
+	if s.locks.HeldByOther(sessionID, stateLockResource, serverReplicaID) {
+		return fmt.Errorf("session state is locked by another writer")
+	}
+
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
 
-	session, exists := s.sessions[sessionID]
-	if !exists {
+	session, err := s.repo.Get(sessionID)
+	if err != nil {
 		return fmt.Errorf("session not found: %s", sessionID)
 	}
 
 	session.Status = status
 	session.UpdatedAt = time.Now()
-	session.Version++
+	session.Version = session.Version.Increment(serverReplicaID)
 
-	return nil
+	return s.repo.Update(session)
 }
 
 func (s *SessionStore) ListSessions() []*Session {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	sessions, err := s.repo.List()
+	if err != nil {
+		return nil
+	}
 
-	var sessions []*Session
 	now := time.Now()
-
-	for _, session := range s.sessions {
+	var live []*Session
+	for _, session := range sessions {
 		if now.Before(session.ExpiresAt) {
-			sessions = append(sessions, session)
+			live = append(live, session)
 		}
 	}
 
-	return sessions
+	return live
+}
+
+// DeleteSession authenticates token, then deletes the session it names.
+func (s *SessionStore) DeleteSession(token string) error {
+	session, err := s.Authenticate(token)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Delete(session.ID)
 }
 
-func (s *SessionStore) DeleteSession(sessionID string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// ListSessionsFiltered streams live sessions matching predicate (nil
+// matches everything) over a channel instead of returning a slice the
+// way ListSessions does. It still loads every session with a single
+// repo.List call before sending the first one, so the full set is held
+// in memory for the duration of the walk.
+func (s *SessionStore) ListSessionsFiltered(predicate func(*Session) bool) <-chan *Session {
+	out := make(chan *Session)
+
+	go func() {
+		defer close(out)
+
+		sessions, err := s.repo.List()
+		if err != nil {
+			return
+		}
+
+		now := time.Now()
+		for _, session := range sessions {
+			if !now.Before(session.ExpiresAt) {
+				continue
+			}
+			if predicate != nil && !predicate(session) {
+				continue
+			}
+			out <- session
+		}
+	}()
 
-	delete(s.sessions, sessionID)
-	return nil
+	return out
 }
 
 func (s *SessionStore) cleanupExpiredSessions() {
-	ticker := time.NewTicker(1 * time.Hour)
+	ticker := time.NewTicker(s.gcInterval)
 	defer ticker.Stop()
 
-	for {
-		select {
-		case <-ticker.C:
-			s.mutex.Lock()
-			now := time.Now()
-			
-			for sessionID, session := range s.sessions {
-				if now.After(session.ExpiresAt) {
-					delete(s.sessions, sessionID)
-				}
+	for range ticker.C {
+		expired, err := s.repo.GC(time.Now())
+		if err != nil {
+			continue
+		}
+
+		if s.OnExpire != nil {
+			for _, sessionID := range expired {
+				s.OnExpire(sessionID)
 			}
-			
-			s.mutex.Unlock()
 		}
 	}
 }
@@ -177,4 +413,4 @@ func generateSessionID() (string, error) {
 		return "", err
 	}
 	return hex.EncodeToString(bytes), nil
-}
\ No newline at end of file
+}
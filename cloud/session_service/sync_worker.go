@@ -10,6 +10,7 @@ import (
 
 type SyncWorker struct {
 	sessionStore *SessionStore
+	wal          *WAL
 	syncQueue    chan SyncJob
 	workers      int
 	wg           sync.WaitGroup
@@ -17,6 +18,7 @@ type SyncWorker struct {
 }
 
 type SyncJob struct {
+	Seq       uint64
 	SessionID string
 	Operation string
 	Payload   map[string]interface{}
@@ -30,22 +32,54 @@ type SyncResult struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
-func NewSyncWorker(sessionStore *SessionStore, workers int) *SyncWorker {
+func NewSyncWorker(sessionStore *SessionStore, workers int, walDir string) (*SyncWorker, error) {
+	wal, err := NewWAL(walDir, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sync wal: %v", err)
+	}
+
 	return &SyncWorker{
 		sessionStore: sessionStore,
+		wal:          wal,
 		syncQueue:    make(chan SyncJob, 100),
 		workers:      workers,
 		shutdown:     make(chan bool),
-	}
+	}, nil
 }
 
-func (sw *SyncWorker) Start() {
-	log.Printf("Starting %d sync workers", sw.workers)
-	
+// Start replays any un-acked WAL records from a previous crash, in seq
+// order, before opening the queue to new jobs.
+func (sw *SyncWorker) Start() error {
+	pending, err := sw.wal.PendingRecords()
+	if err != nil {
+		return fmt.Errorf("failed to replay sync wal: %v", err)
+	}
+
+	log.Printf("Starting %d sync workers (%d pending WAL records to replay)", sw.workers, len(pending))
+
 	for i := 0; i < sw.workers; i++ {
 		sw.wg.Add(1)
 		go sw.worker(i)
 	}
+
+	for _, rec := range pending {
+		sw.syncQueue <- jobFromRecord(rec)
+	}
+
+	return nil
+}
+
+func jobFromRecord(rec WALRecord) SyncJob {
+	var payload map[string]interface{}
+	json.Unmarshal(rec.Payload, &payload)
+
+	return SyncJob{
+		Seq:       rec.Seq,
+		SessionID: rec.SessionID,
+		Operation: rec.Op,
+		Payload:   payload,
+		Timestamp: time.Unix(0, rec.Timestamp),
+	}
 }
 
 func (sw *SyncWorker) Stop() {
@@ -55,20 +89,25 @@ func (sw *SyncWorker) Stop() {
 	close(sw.syncQueue)
 }
 
-func (sw *SyncWorker) QueueSync(sessionID string, operation string, payload map[string]interface{}) {
+// QueueSync durably persists the job to the WAL before handing it to a
+// worker, so a crash between accepting the job and processing it no
+// longer silently drops work.
+func (sw *SyncWorker) QueueSync(sessionID string, operation string, payload map[string]interface{}) error {
+	seq, err := sw.wal.Append(sessionID, operation, payload)
+	if err != nil {
+		return fmt.Errorf("failed to persist sync job: %v", err)
+	}
+
 	job := SyncJob{
+		Seq:       seq,
 		SessionID: sessionID,
 		Operation: operation,
 		Payload:   payload,
 		Timestamp: time.Now(),
 	}
-	
-	select {
-	case sw.syncQueue <- job:
-		// Job queued successfully
-	default:
-		log.Printf("Sync queue full, dropping job for session %s", sessionID)
-	}
+
+	sw.syncQueue <- job
+	return nil
 }
 
 func (sw *SyncWorker) worker(id int) {
@@ -82,8 +121,15 @@ func (sw *SyncWorker) worker(id int) {
 			result := sw.processJob(job)
 			if !result.Success {
 				log.Printf("Worker %d: Sync failed for session %s: %s", id, job.SessionID, result.Error)
+				continue
 			}
-			
+
+			if job.Seq != 0 {
+				if err := sw.wal.Ack(job.Seq); err != nil {
+					log.Printf("Worker %d: failed to ack WAL seq %d: %v", id, job.Seq, err)
+				}
+			}
+
 		case <-sw.shutdown:
 			log.Printf("Sync worker %d shutting down", id)
 			return
@@ -130,25 +176,51 @@ func (sw *SyncWorker) processJob(job SyncJob) SyncResult {
 }
 
 func (sw *SyncWorker) processDeltaSync(job SyncJob) error {
-	// Extract delta from payload
-	delta, ok := job.Payload["delta"].(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("invalid delta payload")
+	// Extract the replica ID and op-set from payload
+	replicaID, _ := job.Payload["replica_id"].(string)
+
+	ops, err := opsFromPayload(job.Payload["ops"])
+	if err != nil {
+		return fmt.Errorf("invalid delta payload: %v", err)
 	}
-	
-	// Apply delta to session
-	return sw.sessionStore.SyncSession(job.SessionID, delta, nil)
+
+	// Merge the op-set into the session's CRDT document. job.SessionID is
+	// a trusted internal ID (from our own WAL, not a client-presented
+	// token), so this goes through the unauthenticated byID path.
+	return sw.sessionStore.syncSessionByID(job.SessionID, replicaID, ops)
 }
 
 func (sw *SyncWorker) processSnapshotSync(job SyncJob) error {
-	// Extract snapshot from payload
-	snapshot, ok := job.Payload["snapshot"].(map[string]interface{})
+	replicaID, _ := job.Payload["replica_id"].(string)
+
+	state, ok := job.Payload["state"].(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("invalid snapshot payload")
 	}
-	
+
 	// Replace session state with snapshot
-	return sw.sessionStore.SyncSession(job.SessionID, nil, snapshot)
+	return sw.sessionStore.ReplaceSessionState(job.SessionID, replicaID, state)
+}
+
+// opsFromPayload round-trips the raw "ops" payload value through JSON
+// into a CRDTOpSet, since job.Payload arrives as generic
+// map[string]interface{} off the wire/WAL.
+func opsFromPayload(raw interface{}) (CRDTOpSet, error) {
+	var ops CRDTOpSet
+	if raw == nil {
+		return ops, fmt.Errorf("missing ops")
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return ops, err
+	}
+
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return ops, err
+	}
+
+	return ops, nil
 }
 
 func (sw *SyncWorker) processStatusUpdate(job SyncJob) error {
@@ -158,8 +230,9 @@ func (sw *SyncWorker) processStatusUpdate(job SyncJob) error {
 		return fmt.Errorf("invalid status payload")
 	}
 	
-	// Update session status
-	return sw.sessionStore.UpdateSessionStatus(job.SessionID, status)
+	// Update session status. job.SessionID is a trusted internal ID, so
+	// this goes through the unauthenticated byID path.
+	return sw.sessionStore.updateSessionStatusByID(job.SessionID, status)
 }
 
 func (sw *SyncWorker) GetQueueStats() map[string]interface{} {
@@ -170,19 +243,36 @@ func (sw *SyncWorker) GetQueueStats() map[string]interface{} {
 	}
 }
 
-// Atomic write operations with versioning
-func (sw *SyncWorker) AtomicSync(sessionID string, expectedVersion int, updates map[string]interface{}) error {
-	session, err := sw.sessionStore.GetSession(sessionID)
+// Atomic write operations with versioning. sessionID is a trusted
+// internal ID, not a client-presented token, so this goes through the
+// unauthenticated byID path.
+func (sw *SyncWorker) AtomicSync(sessionID string, expectedVersion VectorClock, replicaID string, updates map[string]interface{}) error {
+	session, err := sw.sessionStore.getSessionByID(sessionID)
 	if err != nil {
 		return err
 	}
-	
-	if session.Version != expectedVersion {
-		return fmt.Errorf("version conflict: expected %d, got %d", expectedVersion, session.Version)
+
+	if !session.Version.Equal(expectedVersion) {
+		conflictErr := fmt.Errorf("version conflict: expected %v, got %v", expectedVersion, session.Version)
+
+		if dlErr := sw.wal.AppendDeadLetter(sessionID, "atomic_sync_conflict", map[string]interface{}{
+			"expected_version": expectedVersion,
+			"actual_version":   session.Version,
+			"updates":          updates,
+		}); dlErr != nil {
+			log.Printf("failed to dead-letter version conflict for session %s: %v", sessionID, dlErr)
+		}
+
+		return conflictErr
 	}
-	
+
 	// Apply updates atomically
-	return sw.sessionStore.SyncSession(sessionID, updates, nil)
+	return sw.sessionStore.SetSessionKeys(sessionID, replicaID, updates)
+}
+
+// WALStats exposes the underlying WAL's health for GET /api/v1/sync/wal/stats.
+func (sw *SyncWorker) WALStats() (WALStats, error) {
+	return sw.wal.Stats()
 }
 
 // Batch sync operations
@@ -0,0 +1,72 @@
+package main
+
+// VectorClock tracks, per replica ID, how many writes that replica has
+// contributed to a session. Session.Version became one of these (instead
+// of a single counter) so clients can tell which of their own or a
+// peer's deltas the server has and hasn't seen yet.
+type VectorClock map[string]uint64
+
+func (vc VectorClock) Clone() VectorClock {
+	out := make(VectorClock, len(vc))
+	for replica, counter := range vc {
+		out[replica] = counter
+	}
+	return out
+}
+
+// Increment returns a copy of vc with replicaID's counter bumped by one.
+func (vc VectorClock) Increment(replicaID string) VectorClock {
+	out := vc.Clone()
+	out[replicaID]++
+	return out
+}
+
+// Merge returns the component-wise max of vc and other.
+func (vc VectorClock) Merge(other VectorClock) VectorClock {
+	out := vc.Clone()
+	for replica, counter := range other {
+		if counter > out[replica] {
+			out[replica] = counter
+		}
+	}
+	return out
+}
+
+// Equal reports whether vc and other have identical counters for every
+// replica (a replica absent from one side counts as zero).
+func (vc VectorClock) Equal(other VectorClock) bool {
+	return vc.contains(other) && other.contains(vc)
+}
+
+func (vc VectorClock) contains(other VectorClock) bool {
+	for replica, counter := range other {
+		if vc[replica] != counter {
+			return false
+		}
+	}
+	return true
+}
+
+// Dominates reports whether vc has seen at least as much as other from
+// every replica other mentions — i.e. vc is caught up with other.
+func (vc VectorClock) Dominates(other VectorClock) bool {
+	for replica, counter := range other {
+		if vc[replica] < counter {
+			return false
+		}
+	}
+	return true
+}
+
+// MissingSince returns, for each replica where other is ahead of vc, how
+// many of that replica's deltas vc hasn't seen — what a client sends the
+// server to ask "what am I missing".
+func (vc VectorClock) MissingSince(other VectorClock) map[string]uint64 {
+	missing := make(map[string]uint64)
+	for replica, counter := range other {
+		if counter > vc[replica] {
+			missing[replica] = counter - vc[replica]
+		}
+	}
+	return missing
+}
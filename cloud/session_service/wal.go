@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultSegmentBytes int64 = 64 * 1024 * 1024 // 64 MB
+
+// WALRecord is the unit the sync queue persists before a job ever reaches
+// a worker, so jobs survive a crash between being queued and being acked.
+type WALRecord struct {
+	Seq       uint64          `json:"seq"`
+	Timestamp int64           `json:"ts"`
+	SessionID string          `json:"session_id"`
+	Op        string          `json:"op"`
+	Payload   json.RawMessage `json:"payload_json"`
+}
+
+// segment tracks one rotated-through WAL file: the range of sequence
+// numbers it holds and which of those have been acked, so Start() can
+// replay exactly what's missing and finished segments can be reaped.
+type segment struct {
+	index int
+	path  string
+	acked map[uint64]bool
+	seqs  []uint64
+}
+
+func (s *segment) ackPath() string {
+	return s.path + ".ack"
+}
+
+func (s *segment) fullyAcked() bool {
+	if len(s.seqs) == 0 {
+		return false
+	}
+	for _, seq := range s.seqs {
+		if !s.acked[seq] {
+			return false
+		}
+	}
+	return true
+}
+
+// WAL is a write-ahead log for sync jobs: every QueueSync call appends a
+// length-prefixed, CRC32-checksummed record to the current segment and
+// fsyncs before the job is handed to a worker. Segments rotate at
+// maxSegmentBytes and are only deleted once every record in them has an
+// acknowledged result.
+type WAL struct {
+	dir             string
+	maxSegmentBytes int64
+
+	mu       sync.Mutex
+	file     *os.File
+	segments []*segment
+	curBytes int64
+
+	seq uint64 // atomic
+
+	deadLetterMu   sync.Mutex
+	deadLetterFile *os.File
+}
+
+// NewWAL opens (creating if necessary) a WAL rooted at dir. Callers must
+// still call Start() to replay un-acked records before accepting new jobs.
+func NewWAL(dir string, maxSegmentBytes int64) (*WAL, error) {
+	if maxSegmentBytes <= 0 {
+		maxSegmentBytes = defaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create wal dir: %v", err)
+	}
+
+	w := &WAL{dir: dir, maxSegmentBytes: maxSegmentBytes}
+
+	if err := w.loadSegments(); err != nil {
+		return nil, err
+	}
+	if err := w.openDeadLetterFile(); err != nil {
+		return nil, err
+	}
+	if err := w.openCurrentSegment(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *WAL) segmentPath(index int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("seg-%08d.wal", index))
+}
+
+func (w *WAL) deadLetterPath() string {
+	return filepath.Join(w.dir, "dead-letter.wal")
+}
+
+func (w *WAL) loadSegments() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	var indexes []int
+	for _, e := range entries {
+		var idx int
+		if _, err := fmt.Sscanf(e.Name(), "seg-%08d.wal", &idx); err == nil {
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Ints(indexes)
+
+	for _, idx := range indexes {
+		seg, err := w.readSegment(idx)
+		if err != nil {
+			return fmt.Errorf("failed to read segment %d: %v", idx, err)
+		}
+		w.segments = append(w.segments, seg)
+		for _, s := range seg.seqs {
+			if s >= w.seq {
+				w.seq = s
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *WAL) readSegment(index int) (*segment, error) {
+	seg := &segment{index: index, path: w.segmentPath(index), acked: make(map[uint64]bool)}
+
+	records, err := readRecords(seg.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		seg.seqs = append(seg.seqs, r.Seq)
+	}
+
+	if data, err := os.ReadFile(seg.ackPath()); err == nil {
+		var acked []uint64
+		if err := json.Unmarshal(data, &acked); err == nil {
+			for _, s := range acked {
+				seg.acked[s] = true
+			}
+		}
+	}
+
+	return seg, nil
+}
+
+func readRecords(path string) ([]WALRecord, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []WALRecord
+	for offset := 0; offset < len(data); {
+		if offset+4 > len(data) {
+			break // truncated trailing write, stop replay here
+		}
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		if offset+length+4 > len(data) {
+			break
+		}
+		body := data[offset : offset+length]
+		offset += length
+		wantCRC := binary.BigEndian.Uint32(data[offset : offset+4])
+		offset += 4
+
+		if crc32.ChecksumIEEE(body) != wantCRC {
+			break // corrupt trailing record, stop replay here
+		}
+
+		var rec WALRecord
+		if err := json.Unmarshal(body, &rec); err != nil {
+			break
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+func (w *WAL) openCurrentSegment() error {
+	index := 0
+	if len(w.segments) > 0 {
+		index = w.segments[len(w.segments)-1].index
+	} else {
+		w.segments = append(w.segments, &segment{index: 0, path: w.segmentPath(0), acked: make(map[uint64]bool)})
+	}
+
+	f, err := os.OpenFile(w.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.curBytes = info.Size()
+	return nil
+}
+
+func (w *WAL) openDeadLetterFile() error {
+	f, err := os.OpenFile(w.deadLetterPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.deadLetterFile = f
+	return nil
+}
+
+func (w *WAL) currentSegment() *segment {
+	return w.segments[len(w.segments)-1]
+}
+
+// Append writes sessionID/op/payload as a new record and returns its
+// sequence number. The write is fsynced before Append returns, so a
+// crash immediately after can only lose work that was never acked anyway.
+func (w *WAL) Append(sessionID, op string, payload interface{}) (uint64, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seq := atomic.AddUint64(&w.seq, 1)
+	rec := WALRecord{Seq: seq, Timestamp: time.Now().UnixNano(), SessionID: sessionID, Op: op, Payload: payloadJSON}
+
+	if err := w.writeRecord(w.file, rec); err != nil {
+		return 0, err
+	}
+
+	seg := w.currentSegment()
+	seg.seqs = append(seg.seqs, seq)
+
+	if w.curBytes >= w.maxSegmentBytes {
+		if err := w.rotate(); err != nil {
+			return seq, err
+		}
+	}
+
+	return seq, nil
+}
+
+func (w *WAL) writeRecord(f *os.File, rec WALRecord) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(body)))
+
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(body))
+
+	if _, err := f.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		return err
+	}
+	if _, err := f.Write(trailer[:]); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if f == w.file {
+		w.curBytes += int64(4 + len(body) + 4)
+	}
+
+	return nil
+}
+
+// rotate must be called with w.mu held.
+func (w *WAL) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	nextIndex := w.currentSegment().index + 1
+	seg := &segment{index: nextIndex, path: w.segmentPath(nextIndex), acked: make(map[uint64]bool)}
+	w.segments = append(w.segments, seg)
+
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.file = f
+	w.curBytes = 0
+	return nil
+}
+
+// Ack records that seq has an acknowledged SyncResult, persists the
+// segment's ack bitmap, and reaps any older segment that is now fully
+// acked (never the current, still-being-written segment).
+func (w *WAL) Ack(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var target *segment
+	for _, seg := range w.segments {
+		for _, s := range seg.seqs {
+			if s == seq {
+				target = seg
+				break
+			}
+		}
+		if target != nil {
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("wal: unknown seq %d", seq)
+	}
+
+	target.acked[seq] = true
+	if err := w.persistAckBitmap(target); err != nil {
+		return err
+	}
+
+	return w.reapAckedSegments()
+}
+
+func (w *WAL) persistAckBitmap(seg *segment) error {
+	var acked []uint64
+	for s := range seg.acked {
+		acked = append(acked, s)
+	}
+	sort.Slice(acked, func(i, j int) bool { return acked[i] < acked[j] })
+
+	data, err := json.Marshal(acked)
+	if err != nil {
+		return err
+	}
+
+	tmp := seg.ackPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, seg.ackPath())
+}
+
+// reapAckedSegments must be called with w.mu held.
+func (w *WAL) reapAckedSegments() error {
+	kept := w.segments[:0]
+	for i, seg := range w.segments {
+		isCurrent := i == len(w.segments)-1
+		if !isCurrent && seg.fullyAcked() {
+			os.Remove(seg.path)
+			os.Remove(seg.ackPath())
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	w.segments = kept
+	return nil
+}
+
+// PendingRecords returns every un-acked record across all segments, in
+// seq order, for replay at startup.
+func (w *WAL) PendingRecords() ([]WALRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var pending []WALRecord
+	for _, seg := range w.segments {
+		records, err := readRecords(seg.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if !seg.acked[r.Seq] {
+				pending = append(pending, r)
+			}
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Seq < pending[j].Seq })
+	return pending, nil
+}
+
+// AppendDeadLetter records a job that failed permanently (e.g. an
+// AtomicSync version conflict) to a segment operators can inspect and
+// replay separately from the normal ack/reap lifecycle.
+func (w *WAL) AppendDeadLetter(sessionID, op string, payload interface{}) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	w.deadLetterMu.Lock()
+	defer w.deadLetterMu.Unlock()
+
+	seq := atomic.AddUint64(&w.seq, 1)
+	rec := WALRecord{Seq: seq, Timestamp: time.Now().UnixNano(), SessionID: sessionID, Op: op, Payload: payloadJSON}
+	return w.writeRecord(w.deadLetterFile, rec)
+}
+
+// DeadLetters returns every record ever appended via AppendDeadLetter.
+func (w *WAL) DeadLetters() ([]WALRecord, error) {
+	return readRecords(w.deadLetterPath())
+}
+
+// WALStats summarizes WAL health for GET /api/v1/sync/wal/stats.
+type WALStats struct {
+	OldestUnackedSeq uint64 `json:"oldest_unacked_seq"`
+	TotalPending     int    `json:"total_pending"`
+	SegmentCount     int    `json:"segment_count"`
+}
+
+func (w *WAL) Stats() (WALStats, error) {
+	pending, err := w.PendingRecords()
+	if err != nil {
+		return WALStats{}, err
+	}
+
+	w.mu.Lock()
+	segmentCount := len(w.segments)
+	w.mu.Unlock()
+
+	stats := WALStats{TotalPending: len(pending), SegmentCount: segmentCount}
+	if len(pending) > 0 {
+		stats.OldestUnackedSeq = pending[0].Seq
+	}
+
+	return stats, nil
+}